@@ -0,0 +1,11 @@
+//go:build windows
+
+package cmd
+
+import (
+	"os/exec"
+)
+
+// killProcessGroup leaves cmd as it is. On Windows only cmd itself is killed
+// when it is canceled.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -1,34 +1,174 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-shellwords"
 )
 
-func Run(cmdline string) (string, error) {
+// ErrNoCommand is returned for a command line that has no command to run,
+// e.g. one that is blank or only sets environment variables.
+var ErrNoCommand = errors.New("no command to run")
+
+const waitDelay = 5 * time.Second
+
+// Parse splits cmdline into the leading environment variables and the
+// command with its arguments.
+func Parse(cmdline string) ([]string, []string, error) {
 	envs, args, err := shellwords.ParseWithEnvs(cmdline)
 
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, nil, ErrNoCommand
+	}
+
+	return envs, args, nil
+}
+
+// Command is a command that is run without a shell.
+type Command struct {
+	// Args is the command and its arguments.
+	Args []string
+	// Env is the environment of the command in "KEY=value" form.
+	Env []string
+	// InheritEnv adds Env to the environment of the current process instead
+	// of replacing it.
+	InheritEnv bool
+	// Dir is the working directory of the command.
+	Dir string
+	// Log, if set, is called with each line of the output as soon as it is
+	// written.
+	Log func(line string)
+}
+
+// Run runs c and returns its combined output. If ctx is done before the
+// command exits, the command and its process group are killed and the output
+// written so far is returned with an error that includes the elapsed time.
+func (c *Command) Run(ctx context.Context) (string, error) {
+	if len(c.Args) == 0 {
+		return "", ErrNoCommand
 	}
 
-	var cmd *exec.Cmd
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Dir = c.Dir
+	killProcessGroup(cmd)
+
+	// Do not wait forever for a process that inherited the output but was not
+	// killed along with the command.
+	cmd.WaitDelay = waitDelay
 
-	if len(args) > 1 {
-		cmd = exec.Command(args[0], args[1:]...)
+	if c.InheritEnv {
+		if len(c.Env) > 0 {
+			cmd.Env = append(os.Environ(), c.Env...)
+		}
 	} else {
-		cmd = exec.Command(args[0])
+		cmd.Env = append([]string{}, c.Env...)
 	}
 
-	if len(envs) > 0 {
-		cmd.Env = append(os.Environ(), envs...)
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var lw *lineWriter
+
+	if c.Log != nil {
+		lw = &lineWriter{log: c.Log}
+		w = io.MultiWriter(&buf, lw)
+	}
+
+	// The same writer for both makes exec copy them through a single pipe,
+	// which keeps stdout and stderr lines in order.
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	start := time.Now()
+	err := cmd.Run()
+
+	if lw != nil {
+		lw.flush()
 	}
 
-	buf, err := cmd.CombinedOutput()
-	out := strings.TrimSpace(string(buf)) + "\n"
+	out := strings.TrimSpace(buf.String()) + "\n"
+
+	if err != nil && ctx.Err() != nil {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		err = fmt.Errorf("killed after %s: %w", elapsed, ctx.Err())
+	}
 
 	return out, err
 }
+
+// ParseCommand returns the command that runs cmdline in dir.
+//
+// Without an interpreter, cmdline is split into words by Parse and run
+// directly, so shell syntax such as pipes or redirects is not supported. With
+// an interpreter (e.g. ["/bin/sh", "-c"]), cmdline is passed to it as the last
+// argument and can be a whole script.
+func ParseCommand(dir string, interpreter []string, cmdline string) (*Command, error) {
+	c := &Command{Dir: dir, InheritEnv: true}
+
+	if len(interpreter) > 0 {
+		c.Args = append(append(c.Args, interpreter...), cmdline)
+	} else {
+		var err error
+		c.Env, c.Args, err = Parse(cmdline)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Run runs cmdline in dir like Command.Run. See ParseCommand for how cmdline
+// is run.
+func Run(ctx context.Context, dir string, interpreter []string, cmdline string) (string, error) {
+	c, err := ParseCommand(dir, interpreter, cmdline)
+
+	if err != nil {
+		return "", err
+	}
+
+	return c.Run(ctx)
+}
+
+// lineWriter calls log with each line written to it, without the trailing
+// newline.
+type lineWriter struct {
+	log     func(line string)
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+
+		if i < 0 {
+			break
+		}
+
+		w.log(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.log(string(w.partial))
+		w.partial = nil
+	}
+}
@@ -1,8 +1,11 @@
 package cmd_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -20,7 +23,7 @@ func TestRun_OK(t *testing.T) {
 	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
 	os.WriteFile("world.rb", []byte("puts 'hello'"), 0755)
 
-	out, err := cmd.Run("ls ./")
+	out, err := cmd.Run(context.Background(), ".", nil, "ls ./")
 	require.NoError(err)
 	assert.Equal(`hello.rb
 world.rb
@@ -38,7 +41,7 @@ func TestRun_Err(t *testing.T) {
 	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
 	os.WriteFile("world.rb", []byte("puts 'hello'"), 0755)
 
-	out, err := cmd.Run("ls /not/exist")
+	out, err := cmd.Run(context.Background(), ".", nil, "ls /not/exist")
 	require.Error(err)
 	assert.NotEmpty(out)
 }
@@ -51,7 +54,125 @@ func TestRun_WithEnv(t *testing.T) {
 	os.Chdir(t.TempDir())
 	defer os.Chdir(cwd)
 
-	out, err := cmd.Run("FOO=bar ZOO=baz sh -c 'echo $FOO $ZOO'")
+	out, err := cmd.Run(context.Background(), ".", nil, "FOO=bar ZOO=baz sh -c 'echo $FOO $ZOO'")
 	require.NoError(err)
 	assert.Equal("bar baz\n", out)
 }
+
+func TestRun_Dir(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "hello.rb"), []byte("puts 'world'"), 0755)
+
+	out, err := cmd.Run(context.Background(), dir, nil, "ls ./")
+	require.NoError(err)
+	assert.Equal("hello.rb\n", out)
+}
+
+func TestRun_NoCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, cmdline := range []string{"", "  ", "FOO=bar"} {
+		_, err := cmd.Run(context.Background(), ".", nil, cmdline)
+		assert.ErrorIs(err, cmd.ErrNoCommand)
+	}
+}
+
+func TestRun_Interpreter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	script := `
+		echo hello > hello.txt
+		cat hello.txt | tr a-z A-Z && ls *.txt
+	`
+
+	out, err := cmd.Run(context.Background(), dir, []string{"/bin/sh", "-c"}, script)
+	require.NoError(err)
+	assert.Equal("HELLO\nhello.txt\n", out)
+
+	out, err = cmd.Run(context.Background(), dir, []string{"/bin/sh", "-c"}, "echo error; exit 3")
+	assert.ErrorContains(err, "exit status 3")
+	assert.Equal("error\n", out)
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	envs, args, err := cmd.Parse("FOO=bar BAZ='zoo zoo' echo -n $FOO")
+	require.NoError(err)
+	assert.Equal([]string{"FOO=bar", "BAZ=zoo zoo"}, envs)
+	assert.Equal([]string{"echo", "-n", "$FOO"}, args)
+
+	_, _, err = cmd.Parse("echo 'hello")
+	assert.Error(err)
+}
+
+func TestRun_Timeout(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// The background sleep keeps the output open, so Run returns promptly only
+	// if the whole process group is killed.
+	start := time.Now()
+	out, err := cmd.Run(ctx, t.TempDir(), []string{"/bin/sh", "-c"}, "echo started; sleep 30 & sleep 30")
+	assert.Less(time.Since(start), 3*time.Second)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.ErrorContains(err, "killed after")
+	assert.Equal("started\n", out)
+}
+
+func TestCommand_Run(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	t.Setenv("LAMBDAZIP_TEST", "inherited")
+	dir := t.TempDir()
+
+	c := &cmd.Command{
+		Args:       []string{"sh", "-c", "echo $FOO ${LAMBDAZIP_TEST:-none}; pwd"},
+		Env:        []string{"FOO=foo bar"},
+		InheritEnv: true,
+		Dir:        dir,
+	}
+
+	out, err := c.Run(context.Background())
+	require.NoError(err)
+	realDir, _ := filepath.EvalSymlinks(dir)
+	assert.Equal("foo bar inherited\n"+realDir+"\n", out)
+
+	c.Args = []string{"/bin/sh", "-c", "echo $FOO ${LAMBDAZIP_TEST:-none}"}
+	c.InheritEnv = false
+	out, err = c.Run(context.Background())
+	require.NoError(err)
+	assert.Equal("foo bar none\n", out)
+
+	_, err = (&cmd.Command{}).Run(context.Background())
+	assert.ErrorIs(err, cmd.ErrNoCommand)
+}
+
+func TestCommand_RunLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	lines := []string{}
+
+	c := &cmd.Command{
+		Args:       []string{"/bin/sh", "-c", "echo out; echo err >&2; printf 'no newline'"},
+		InheritEnv: true,
+		Log: func(line string) {
+			lines = append(lines, line)
+		},
+	}
+
+	out, err := c.Run(context.Background())
+	require.NoError(err)
+	assert.Equal("out\nerr\nno newline\n", out)
+	assert.Equal([]string{"out", "err", "no newline"}, lines)
+}
@@ -1,54 +1,373 @@
 package glob
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 
 	"github.com/bmatcuk/doublestar/v4"
 )
 
-func Glob(patterns []string, excludes []string, opts ...doublestar.GlobOption) ([]string, error) {
-	fileSet := map[string]struct{}{}
+// Result is the result of Match.
+type Result struct {
+	// Files is the files that match the patterns and do not match the
+	// excludes.
+	Files []string
+	// Dirs is the directories that are named by the patterns or are found
+	// under a named directory, and the directories that match a wildcard and
+	// are empty or have other matches under them. Dirs that match the
+	// excludes are not included.
+	Dirs []string
+	// Unmatched is the patterns that match nothing, before excludes are
+	// applied.
+	Unmatched []string
+	// DirsOnly is the patterns that match only directories without files
+	// under them, before excludes are applied.
+	DirsOnly []string
+}
+
+// Glob returns the files under root that match patterns and do not match
+// excludes. Directories are not expanded. The returned paths are relative to
+// root unless the pattern is absolute.
+func Glob(root string, patterns []string, excludes []string, opts ...doublestar.GlobOption) ([]string, error) {
+	r, err := match(root, patterns, excludes, false, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Files, nil
+}
 
-	opts = append(opts,
-		doublestar.WithFilesOnly(),
-		doublestar.WithFailOnIOErrors(),
-	)
+// Match works like Glob, but a pattern that names a directory without a
+// wildcard in its last component, e.g. "config" or "app/*/config", is
+// expanded to the files under it. It also returns the directories and the
+// patterns that match no files.
+func Match(root string, patterns []string, excludes []string, opts ...doublestar.GlobOption) (*Result, error) {
+	return match(root, patterns, excludes, true, opts...)
+}
+
+func match(root string, patterns []string, excludes []string, expand bool, opts ...doublestar.GlobOption) (*Result, error) {
+	fileSet := map[string]struct{}{}
+	dirSet := map[string]struct{}{}
+	matchedDirs := []string{}
+	dirsByPattern := map[string][]string{}
+	unmatched := []string{}
+	dirsOnly := []string{}
 
 	for _, pat := range patterns {
-		matches, err := doublestar.FilepathGlob(pat, opts...)
+		pattern := filepath.ToSlash(filepath.Clean(pat))
+		base, pattern := doublestar.SplitPattern(pattern)
+		files, dirs, err := collect(root, filepath.FromSlash(base), pattern, opts...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// A wildcard that happens to match a directory, e.g. "*" and
+		// node_modules, does not add the files under it. The directory is kept
+		// only if it is empty or the pattern reaches into it, e.g. "**".
+		if !expand || hasMeta(path.Base(pattern)) {
+			dirs, err = keepDirs(root, dirs, files)
+
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			matchedDirs = append(matchedDirs, dirs...)
+		}
+
+		for _, f := range files {
+			fileSet[f] = struct{}{}
+		}
+
+		for _, d := range dirs {
+			// "." is expanded, but is not an entry of its own.
+			if d != "." {
+				dirSet[d] = struct{}{}
+			}
+		}
+
+		if len(files) == 0 && len(dirs) == 0 {
+			unmatched = append(unmatched, pat)
+		} else if len(files) == 0 {
+			dirsOnly = append(dirsOnly, pat)
+			dirsByPattern[pat] = dirs
+		}
+	}
+
+	// Expand each matched directory once. Sorting puts a directory before
+	// the directories under it, which its own expansion already covers.
+	sort.Strings(matchedDirs)
+	expanded := []string{}
+
+	for _, dir := range matchedDirs {
+		if isUnder(dir, expanded) {
+			continue
+		}
+
+		files, dirs, err := collect(root, dir, "**", opts...)
 
 		if err != nil {
 			return nil, err
 		}
 
-		for _, f := range matches {
+		for _, f := range files {
 			fileSet[f] = struct{}{}
 		}
+
+		for _, d := range dirs {
+			dirSet[d] = struct{}{}
+		}
+
+		expanded = append(expanded, dir)
 	}
 
+	// A pattern that matches directories is matched if they have files.
+	if len(dirsOnly) > 0 {
+		parents := parentSet(sorted(fileSet))
+		withoutFiles := []string{}
+
+		for _, pat := range dirsOnly {
+			hasFiles := func(dir string) bool {
+				_, ok := parents[dir]
+				return ok
+			}
+
+			if !slices.ContainsFunc(dirsByPattern[pat], hasFiles) {
+				withoutFiles = append(withoutFiles, pat)
+			}
+		}
+
+		dirsOnly = withoutFiles
+	}
+
+	// Excludes are matched against the names instead of being globbed, so
+	// that they exclude the same files whether or not opts follow symlinks.
+	// An excluded directory excludes everything under it.
+	excludedDirs := map[string]struct{}{}
+
 	for _, exPat := range excludes {
-		exMatches, err := doublestar.FilepathGlob(exPat,
-			doublestar.WithFilesOnly(),
-			doublestar.WithFailOnIOErrors())
+		exPat = filepath.Clean(exPat)
+
+		for f := range fileSet {
+			matched, err := doublestar.PathMatch(exPat, f)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if matched {
+				delete(fileSet, f)
+			}
+		}
+
+		for d := range dirSet {
+			matched, err := doublestar.PathMatch(exPat, d)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if matched {
+				excludedDirs[d] = struct{}{}
+			}
+		}
+	}
+
+	if len(excludedDirs) > 0 {
+		for _, set := range []map[string]struct{}{fileSet, dirSet} {
+			for f := range set {
+				if hasAncestor(f, excludedDirs) {
+					delete(set, f)
+				}
+			}
+		}
+	}
+
+	r := &Result{
+		Files:     sorted(fileSet),
+		Dirs:      sorted(dirSet),
+		Unmatched: unmatched,
+		DirsOnly:  dirsOnly,
+	}
+
+	return r, nil
+}
+
+// EmptyDirs returns the dirs that have none of files or dirs under them.
+func EmptyDirs(files []string, dirs []string) []string {
+	parents := parentSet(files, dirs)
+	empty := []string{}
+
+	for _, dir := range dirs {
+		if _, ok := parents[dir]; !ok {
+			empty = append(empty, dir)
+		}
+	}
+
+	return empty
+}
+
+// collect returns the files and directories under root/base that match
+// pattern. Relative patterns are resolved against root instead of the current
+// working directory.
+func collect(root string, base string, pattern string, opts ...doublestar.GlobOption) ([]string, []string, error) {
+	fsys := os.DirFS(Path(root, base))
+	opts = append(opts, doublestar.WithFailOnIOErrors())
+	matches, err := doublestar.Glob(fsys, pattern, opts...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Whether a match is a directory is left to doublestar, so that a symlink
+	// to a directory is one only when opts follow symlinks.
+	files, err := doublestar.Glob(fsys, pattern, append(opts, doublestar.WithFilesOnly())...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	isFile := map[string]bool{}
+
+	for _, f := range files {
+		isFile[f] = true
+	}
+
+	matchedFiles := []string{}
+	dirs := []string{}
+
+	for _, m := range matches {
+		name := filepath.Join(base, filepath.FromSlash(m))
+
+		switch {
+		case isFile[m]:
+			matchedFiles = append(matchedFiles, name)
+		case m != "." || pattern == ".":
+			dirs = append(dirs, name)
+		}
+	}
+
+	return matchedFiles, dirs, nil
+}
+
+// keepDirs returns the dirs that are empty or have one of names under them.
+func keepDirs(root string, dirs []string, names []string) ([]string, error) {
+	parents := parentSet(names, dirs)
+	kept := []string{}
+
+	for _, dir := range dirs {
+		if _, ok := parents[dir]; ok {
+			kept = append(kept, dir)
+			continue
+		}
+
+		empty, err := isEmptyDir(Path(root, dir))
 
 		if err != nil {
 			return nil, err
+		} else if empty {
+			kept = append(kept, dir)
 		}
+	}
+
+	return kept, nil
+}
+
+// parentSet returns the directories that have any of lists under them.
+func parentSet(lists ...[]string) map[string]struct{} {
+	parents := map[string]struct{}{}
+
+	for _, names := range lists {
+		for _, name := range names {
+			for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+				if _, ok := parents[dir]; ok {
+					break
+				}
+
+				parents[dir] = struct{}{}
 
-		for _, f := range exMatches {
-			delete(fileSet, f)
+				if dir == filepath.Dir(dir) {
+					break
+				}
+			}
 		}
 	}
 
-	files := []string{}
+	return parents
+}
+
+// hasAncestor reports whether name or one of its parent directories is in
+// dirs.
+func hasAncestor(name string, dirs map[string]struct{}) bool {
+	for dir := name; ; dir = filepath.Dir(dir) {
+		if _, ok := dirs[dir]; ok {
+			return true
+		}
+
+		if dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+
+	if errors.Is(err, io.EOF) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+func hasMeta(name string) bool {
+	return strings.ContainsAny(name, `*?[{\`)
+}
+
+// Path returns the path of name, a path returned by Glob or Match, resolved
+// against root.
+func Path(root string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(root, name)
+}
+
+// isUnder reports whether name is one of dirs or is under one of them.
+func isUnder(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if name == dir || strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sorted(set map[string]struct{}) []string {
+	list := make([]string, 0, len(set))
 
-	for f := range fileSet {
-		files = append(files, f)
+	for name := range set {
+		list = append(list, name)
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i] < files[j]
-	})
+	sort.Strings(list)
 
-	return files, nil
+	return list
 }
@@ -2,6 +2,7 @@ package glob_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/bmatcuk/doublestar/v4"
@@ -74,7 +75,7 @@ func TestGlob(_t *testing.T) {
 	}
 
 	for _, t := range tt {
-		files, err := glob.Glob(t.pattern, t.excludes)
+		files, err := glob.Glob(".", t.pattern, t.excludes)
 		require.NoError(err)
 		assert.Equal(t.expected, files)
 	}
@@ -90,9 +91,191 @@ func TestGlobNotExist(_t *testing.T) {
 	os.Mkdir("app", 0755)
 	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0755)
 
-	_, err := glob.Glob([]string{"app/hello.rb"}, []string{}, doublestar.WithFailOnPatternNotExist())
+	_, err := glob.Glob(".", []string{"app/hello.rb"}, []string{}, doublestar.WithFailOnPatternNotExist())
 	assert.NoError(err)
 
-	_, err = glob.Glob([]string{"app/hellox.rb"}, []string{}, doublestar.WithFailOnPatternNotExist())
+	_, err = glob.Glob(".", []string{"app/hellox.rb"}, []string{}, doublestar.WithFailOnPatternNotExist())
 	assert.ErrorContains(err, "pattern does not exist")
 }
+
+func TestGlobRoot(_t *testing.T) {
+	assert := assert.New(_t)
+	require := require.New(_t)
+
+	tmp := _t.TempDir()
+	root := filepath.Join(tmp, "app")
+	os.MkdirAll(filepath.Join(root, "lib"), 0755)
+	os.Mkdir(filepath.Join(tmp, "shared"), 0755)
+	os.WriteFile(filepath.Join(root, "hello.rb"), []byte("puts 'world'"), 0755)
+	os.WriteFile(filepath.Join(root, "lib", "const.rb"), []byte("A = 100"), 0644)
+	os.WriteFile(filepath.Join(tmp, "shared", "util.rb"), []byte("B = 200"), 0644)
+
+	files, err := glob.Glob(root, []string{"**/*.rb", "../shared/*.rb"}, []string{"lib/*"})
+	require.NoError(err)
+	assert.Equal([]string{
+		filepath.Join("..", "shared", "util.rb"),
+		"hello.rb",
+	}, files)
+
+	files, err = glob.Glob(root, []string{filepath.Join(root, "lib", "*.rb")}, []string{})
+	require.NoError(err)
+	assert.Equal([]string{filepath.Join(root, "lib", "const.rb")}, files)
+}
+
+func TestGlobDirs(_t *testing.T) {
+	assert := assert.New(_t)
+	require := require.New(_t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(_t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/config/env", 0755)
+	os.MkdirAll("app/tmp", 0755)
+	os.MkdirAll("app/lib", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0755)
+	os.WriteFile("app/config/app.yml", []byte("name: hello"), 0644)
+	os.WriteFile("app/config/env/prod.yml", []byte("env: prod"), 0644)
+	os.WriteFile("app/lib/const.rb", []byte("A = 100"), 0644)
+	os.MkdirAll("app/vendor/ext.rb", 0755)
+	os.WriteFile("app/vendor/ext.rb/ext.c", []byte("int x;"), 0644)
+
+	tt := []struct {
+		pattern  []string
+		excludes []string
+		files    []string
+		dirs     []string
+		globbed  []string
+	}{
+		{
+			pattern:  []string{"app/config"},
+			excludes: []string{},
+			files: []string{
+				"app/config/app.yml",
+				"app/config/env/prod.yml",
+			},
+			dirs: []string{
+				"app/config",
+				"app/config/env",
+			},
+			globbed: []string{},
+		},
+		{
+			pattern:  []string{"app/*/env"},
+			excludes: []string{},
+			files: []string{
+				"app/config/env/prod.yml",
+			},
+			dirs: []string{
+				"app/config/env",
+			},
+			globbed: []string{},
+		},
+		{
+			// Directories matching a wildcard are not expanded.
+			pattern:  []string{"app/*"},
+			excludes: []string{"app/lib"},
+			files: []string{
+				"app/hello.rb",
+			},
+			dirs: []string{
+				"app/tmp",
+			},
+			globbed: []string{
+				"app/hello.rb",
+			},
+		},
+		{
+			pattern:  []string{"app/**"},
+			excludes: []string{"app/vendor"},
+			files: []string{
+				"app/config/app.yml",
+				"app/config/env/prod.yml",
+				"app/hello.rb",
+				"app/lib/const.rb",
+			},
+			dirs: []string{
+				"app/config",
+				"app/config/env",
+				"app/lib",
+				"app/tmp",
+			},
+			globbed: []string{
+				"app/config/app.yml",
+				"app/config/env/prod.yml",
+				"app/hello.rb",
+				"app/lib/const.rb",
+			},
+		},
+		{
+			pattern:  []string{"."},
+			excludes: []string{"**/*.yml", "app/vendor"},
+			files: []string{
+				"app/hello.rb",
+				"app/lib/const.rb",
+			},
+			dirs: []string{
+				"app",
+				"app/config",
+				"app/config/env",
+				"app/lib",
+				"app/tmp",
+			},
+			globbed: []string{},
+		},
+	}
+
+	for _, t := range tt {
+		r, err := glob.Match(".", t.pattern, t.excludes)
+		require.NoError(err)
+		assert.Equal(t.files, r.Files)
+		assert.Equal(t.dirs, r.Dirs)
+		assert.Empty(r.Unmatched)
+		assert.Empty(r.DirsOnly)
+
+		// Glob does not expand directories.
+		files, err := glob.Glob(".", t.pattern, t.excludes)
+		require.NoError(err)
+		assert.Equal(t.globbed, files)
+	}
+
+	r, err := glob.Match(".", []string{"app/vendor/*.rb"}, []string{})
+	require.NoError(err)
+	assert.Empty(r.Files)
+	assert.Equal([]string{"app/vendor/*.rb"}, r.Unmatched)
+}
+
+func TestEmptyDirs(t *testing.T) {
+	assert := assert.New(t)
+
+	files := []string{"app/bin/hello.rb", "app/config/app.yml"}
+	dirs := []string{"app", "app/bin", "app/config", "app/config/env", "app/tmp", "app/tmp/cache"}
+	assert.Equal([]string{"app/config/env", "app/tmp/cache"}, glob.EmptyDirs(files, dirs))
+}
+
+func TestMatchUnmatched(_t *testing.T) {
+	assert := assert.New(_t)
+	require := require.New(_t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(_t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/lib", 0755)
+	os.MkdirAll("app/tmp", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+	os.WriteFile("app/lib/const.rb", []byte("A = 100"), 0644)
+
+	r, err := glob.Match(".", []string{
+		"app/*.rb",
+		"app/lib",
+		"app/*.py",
+		"app/tmp",
+		"lib/**",
+		".",
+	}, []string{"app/*.rb"})
+	require.NoError(err)
+	assert.Equal([]string{"app/lib/const.rb"}, r.Files)
+	assert.Equal([]string{"app/*.py", "lib/**"}, r.Unmatched)
+	assert.Equal([]string{"app/tmp"}, r.DirsOnly)
+}
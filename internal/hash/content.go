@@ -0,0 +1,50 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Content computes a digest of the entries of an archive from their names,
+// modes and uncompressed data, so that it does not change with the
+// compression level or the compressor.
+type Content struct {
+	entries []contentEntry
+}
+
+type contentEntry struct {
+	name string
+	mode fs.FileMode
+	h    hash.Hash
+}
+
+func NewContent() *Content {
+	return &Content{}
+}
+
+// Entry adds an entry and returns the writer for its uncompressed data.
+func (c *Content) Entry(name string, mode fs.FileMode) io.Writer {
+	e := contentEntry{name: name, mode: mode, h: sha256.New()}
+	c.entries = append(c.entries, e)
+
+	return e.h
+}
+
+// Sha256 returns the hex-encoded sha256 of the entries sorted by name.
+func (c *Content) Sha256() string {
+	entries := make([]contentEntry, len(c.entries))
+	copy(entries, c.entries)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	h := sha256.New()
+
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s\x00%o\x00%x\n", e.name, uint32(e.mode), e.h.Sum(nil))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,33 @@
+package hash_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/terraform-provider-lambdazip/internal/hash"
+)
+
+func TestContent(t *testing.T) {
+	assert := assert.New(t)
+
+	content := func(entries ...string) *hash.Content {
+		c := hash.NewContent()
+
+		for _, name := range entries {
+			io.WriteString(c.Entry(name, 0644), "puts '"+name+"'")
+		}
+
+		return c
+	}
+
+	c := content("hello.rb", "world.rb")
+	assert.Len(c.Sha256(), 64)
+	assert.Equal(c.Sha256(), content("world.rb", "hello.rb").Sha256())
+	assert.NotEqual(c.Sha256(), content("hello.rb").Sha256())
+
+	executable := hash.NewContent()
+	io.WriteString(executable.Entry("hello.rb", 0755), "puts 'hello.rb'")
+	io.WriteString(executable.Entry("world.rb", 0644), "puts 'world.rb'")
+	assert.NotEqual(c.Sha256(), executable.Sha256())
+}
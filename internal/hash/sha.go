@@ -4,34 +4,74 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"os"
+	"sort"
+
+	"github.com/winebarrel/terraform-provider-lambdazip/internal/glob"
 )
 
 func Base64Sha256(file string) (string, error) {
-	buf, err := os.ReadFile(file)
+	sha256Sum, err := sum(sha256.New(), file)
 
 	if err != nil {
 		return "", err
 	}
 
-	sha256Sum := sha256.Sum256(buf)
-	b64 := base64.StdEncoding.EncodeToString(sha256Sum[:])
+	b64 := base64.StdEncoding.EncodeToString(sha256Sum)
 
 	return b64, nil
 }
 
-func Sha256Map(files []string) (map[string]string, error) {
+// Sha256Map returns the hex-encoded sha256 of files keyed by name. Relative
+// names are read from root.
+func Sha256Map(root string, files []string) (map[string]string, error) {
+	return sha256Map(root, files, true)
+}
+
+// Sha256MapNoFollow is like Sha256Map, but a symbolic link is hashed by its
+// target path instead of the file it points to, which may not exist.
+func Sha256MapNoFollow(root string, files []string) (map[string]string, error) {
+	return sha256Map(root, files, false)
+}
+
+func sha256Map(root string, files []string, follow bool) (map[string]string, error) {
 	m := map[string]string{}
 
 	for _, f := range files {
-		buf, err := os.ReadFile(f)
+		name := glob.Path(root, f)
+
+		if !follow {
+			fi, err := os.Lstat(name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if fi.Mode()&fs.ModeSymlink != 0 {
+				target, err := os.Readlink(name)
+
+				if err != nil {
+					return nil, err
+				}
+
+				// Distinguish a link from a file containing its target.
+				sha256Sum := sha256.Sum256([]byte("symlink\x00" + target))
+				m[f] = hex.EncodeToString(sha256Sum[:])
+				continue
+			}
+		}
+
+		sha256Sum, err := sum(sha256.New(), name)
 
 		if err != nil {
 			return nil, err
 		}
 
-		sha256Sum := sha256.Sum256(buf)
-		h := hex.EncodeToString(sha256Sum[:])
+		h := hex.EncodeToString(sha256Sum)
 		m[f] = h
 	}
 
@@ -49,3 +89,54 @@ func ContentsSha256Map(contents map[string]string) map[string]string {
 
 	return m
 }
+
+// Base64Sha256Maps returns the base64-encoded sha256 of maps of digests keyed
+// by name, such as the results of Sha256Map and ContentsSha256Map. Each map is
+// hashed under its key in maps, so that moving a name from one map to another
+// changes the result.
+func Base64Sha256Maps(maps map[string]map[string]string) string {
+	h := sha256.New()
+	keys := make([]string, 0, len(maps))
+
+	for key := range maps {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m := maps[key]
+		names := make([]string, 0, len(m))
+
+		for name := range m {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(h, "%s\x00%s\x00%s\n", key, name, m[name])
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sum returns the digest of file, copied into h in chunks.
+func sum(h hash.Hash, file string) ([]byte, error) {
+	f, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(h, f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
@@ -1,7 +1,12 @@
 package hash_test
 
 import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,7 +40,7 @@ func TestSha256Map(t *testing.T) {
 	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
 	os.WriteFile("world.rb", []byte("puts 'hello'"), 0755)
 
-	m, err := hash.Sha256Map([]string{"hello.rb", "world.rb"})
+	m, err := hash.Sha256Map(".", []string{"hello.rb", "world.rb"})
 	require.NoError(err)
 
 	assert.Equal(map[string]string{
@@ -44,6 +49,45 @@ func TestSha256Map(t *testing.T) {
 	}, m)
 }
 
+func TestSha256MapWithRoot(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "app"), 0755)
+	os.WriteFile(filepath.Join(root, "app", "hello.rb"), []byte("puts 'world'"), 0755)
+
+	m, err := hash.Sha256Map(root, []string{filepath.Join("app", "hello.rb")})
+	require.NoError(err)
+
+	assert.Equal(map[string]string{
+		filepath.Join("app", "hello.rb"): "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe",
+	}, m)
+}
+
+func TestSha256MapNoFollow(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "hello.rb"), []byte("puts 'world'"), 0755)
+	os.WriteFile(filepath.Join(root, "target.txt"), []byte("missing"), 0644)
+	os.Symlink("hello.rb", filepath.Join(root, "link"))
+	os.Symlink("missing", filepath.Join(root, "dangling"))
+
+	_, err := hash.Sha256Map(root, []string{"dangling"})
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	m, err := hash.Sha256MapNoFollow(root, []string{"hello.rb", "link", "dangling", "target.txt"})
+	require.NoError(err)
+	assert.Equal("06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe", m["hello.rb"])
+	assert.Len(m["link"], 64)
+	assert.NotEqual(m["hello.rb"], m["link"])
+	assert.NotEqual(m["link"], m["dangling"])
+	// A link is not the same as a file containing its target
+	assert.NotEqual(m["target.txt"], m["dangling"])
+}
+
 func TestContentsSha256Map(t *testing.T) {
 	assert := assert.New(t)
 
@@ -64,3 +108,45 @@ func TestContentsSha256Map(t *testing.T) {
 		"world.rb": "293c10e07909b3a823d7d2ba87c6cdf7400c9ed70132c2c952d7c8147d945a74",
 	}, m)
 }
+
+func TestBase64Sha256Maps(t *testing.T) {
+	assert := assert.New(t)
+
+	files := map[string]string{"hello.rb": "aa", "world.rb": "bb"}
+	contents := map[string]string{"config.json": "cc"}
+
+	h := hash.Base64Sha256Maps(map[string]map[string]string{"files": files, "contents": contents})
+	assert.Len(h, 44)
+	assert.Equal(h, hash.Base64Sha256Maps(map[string]map[string]string{"contents": contents, "files": files}))
+
+	moved := map[string]string{"world.rb": "bb"}
+	contents["hello.rb"] = "aa"
+	assert.NotEqual(h, hash.Base64Sha256Maps(map[string]map[string]string{"files": moved, "contents": contents}))
+
+	files["world.rb"] = "bc"
+	delete(contents, "hello.rb")
+	assert.NotEqual(h, hash.Base64Sha256Maps(map[string]map[string]string{"files": files, "contents": contents}))
+}
+
+// BenchmarkBase64Sha256 shows that memory use does not grow with the file
+// size (compare B/op between the sub-benchmarks).
+func BenchmarkBase64Sha256(b *testing.B) {
+	for _, size := range []int64{1 << 20, 16 << 20, 64 << 20} {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			name := filepath.Join(b.TempDir(), "large.bin")
+			f, err := os.Create(name)
+			require.NoError(b, err)
+			_, err = io.CopyN(f, rand.NewChaCha8([32]byte{}), size)
+			require.NoError(b, err)
+			require.NoError(b, f.Close())
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+
+			for b.Loop() {
+				_, err := hash.Base64Sha256(name)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package hash
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io"
+)
+
+// Writer computes all of the digests of the data written to it in a single
+// pass, so that a zip file does not have to be read again to hash it.
+type Writer struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	w      io.Writer
+}
+
+func NewWriter() *Writer {
+	w := &Writer{
+		sha256: sha256.New(),
+		md5:    md5.New(),
+	}
+
+	w.w = io.MultiWriter(w.sha256, w.md5)
+
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *Writer) Base64Sha256() string {
+	return base64.StdEncoding.EncodeToString(w.sha256.Sum(nil))
+}
+
+func (w *Writer) Base64Md5() string {
+	return base64.StdEncoding.EncodeToString(w.md5.Sum(nil))
+}
@@ -0,0 +1,24 @@
+package hash_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/winebarrel/terraform-provider-lambdazip/internal/hash"
+)
+
+func TestWriter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	w := hash.NewWriter()
+	_, err := io.WriteString(w, "puts ")
+	require.NoError(err)
+	_, err = io.WriteString(w, "'world'")
+	require.NoError(err)
+
+	assert.Equal("BtsseiYO+vbi4/TGNcg1BvH0D204mODmAl4+VfRN3r4=", w.Base64Sha256())
+	assert.Equal("Ikxb6Lu+E5Jfz8LsrR3Aaw==", w.Base64Md5())
+}
@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/winebarrel/terraform-provider-lambdazip/internal/zip"
+)
+
+var _ validator.String = entryNameValidator{}
+
+// entryNameValidator validates that a string is a safe zip entry name, i.e.
+// not empty, not absolute and not escaping the root of the archive.
+type entryNameValidator struct{}
+
+func (v entryNameValidator) Description(_ context.Context) string {
+	return "value must be a relative path inside the zip file"
+}
+
+func (v entryNameValidator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v entryNameValidator) ValidateString(ctx context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	if req.ConfigValue.IsNull() || req.ConfigValue.IsUnknown() {
+		return
+	}
+
+	_, err := zip.NormalizeName(req.ConfigValue.ValueString())
+
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			req.Path,
+			"Invalid Attribute Value",
+			fmt.Sprintf("Attribute %s %s, got: %s", req.Path, v.Description(ctx), err),
+		)
+	}
+}
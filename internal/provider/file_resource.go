@@ -2,27 +2,37 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/hashicorp/terraform-plugin-framework-validators/int32validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/mapvalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/resourcevalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int32default"
-	"github.com/hashicorp/terraform-plugin-framework/resource/schema/listplanmodifier"
-	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 	cp "github.com/otiai10/copy"
 	"github.com/winebarrel/terraform-provider-lambdazip/internal/cmd"
 	"github.com/winebarrel/terraform-provider-lambdazip/internal/glob"
@@ -31,14 +41,13 @@ import (
 )
 
 var _ resource.ResourceWithConfigValidators = &FileResource{}
+var _ resource.ResourceWithValidateConfig = &FileResource{}
+var _ resource.ResourceWithModifyPlan = &FileResource{}
 
-// chdirMu guards the process-wide working directory. Terraform runs a single
-// provider instance's operations concurrently in one process, so without this
-// lock concurrent builds race on cwd and produce cross-contaminated zips.
-// Create mutates cwd via os.Chdir and takes the write lock; operations that
-// only read cwd-relative paths (the files_sha256 data source) take the read
-// lock so they cannot observe a directory a concurrent Create has chdir'd into.
-var chdirMu sync.RWMutex
+var fileModeRegexp = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// maxBuildLogSize is the size limit of build_log, which is kept in the state.
+const maxBuildLogSize = 16 * 1024
 
 func NewFileResource() resource.Resource {
 	return &FileResource{}
@@ -47,19 +56,44 @@ func NewFileResource() resource.Resource {
 type FileResource struct {
 }
 
+type BuildModel struct {
+	Command    []types.String `tfsdk:"command"`
+	Env        types.Map      `tfsdk:"env"`
+	WorkingDir types.String   `tfsdk:"working_dir"`
+	InheritEnv types.Bool     `tfsdk:"inherit_env"`
+	Timeout    types.String   `tfsdk:"timeout"`
+}
+
 type FileResourceModel struct {
-	BaseDir          types.String   `tfsdk:"base_dir"`
-	Sources          []types.String `tfsdk:"sources"`
-	Contents         types.Map      `tfsdk:"contents"`
-	Excludes         []types.String `tfsdk:"excludes"`
-	Output           types.String   `tfsdk:"output"`
-	BeforeCreate     types.String   `tfsdk:"before_create"`
-	Triggers         types.Map      `tfsdk:"triggers"`
-	Base64sha256     types.String   `tfsdk:"base64sha256"`
-	Base64md5        types.String   `tfsdk:"base64md5"`
-	UseTempDir       types.Bool     `tfsdk:"use_temp_dir"`
-	CompressionLevel types.Int32    `tfsdk:"compression_level"`
-	StripComponents  types.Int32    `tfsdk:"strip_components"`
+	BaseDir             types.String   `tfsdk:"base_dir"`
+	Sources             []types.String `tfsdk:"sources"`
+	Contents            types.Map      `tfsdk:"contents"`
+	Excludes            []types.String `tfsdk:"excludes"`
+	Output              types.String   `tfsdk:"output"`
+	BeforeCreate        types.String   `tfsdk:"before_create"`
+	Build               []BuildModel   `tfsdk:"build"`
+	Interpreter         []types.String `tfsdk:"interpreter"`
+	BeforeCreateTimeout types.String   `tfsdk:"before_create_timeout"`
+	Triggers            types.Map      `tfsdk:"triggers"`
+	Base64sha256        types.String   `tfsdk:"base64sha256"`
+	Base64md5           types.String   `tfsdk:"base64md5"`
+	ContentSha256       types.String   `tfsdk:"content_sha256"`
+	BuildLog            types.String   `tfsdk:"build_log"`
+	UseTempDir          types.Bool     `tfsdk:"use_temp_dir"`
+	CompressionLevel    types.Int32    `tfsdk:"compression_level"`
+	StripComponents     types.Int32    `tfsdk:"strip_components"`
+	DefaultFileMode     types.String   `tfsdk:"default_file_mode"`
+	Reproducible        types.Bool     `tfsdk:"reproducible"`
+	SourceDateEpoch     types.Int64    `tfsdk:"source_date_epoch"`
+	RebuildIfMissing    types.Bool     `tfsdk:"rebuild_if_missing"`
+	DeleteOutput        types.Bool     `tfsdk:"delete_output_on_destroy"`
+	Symlinks            types.String   `tfsdk:"symlinks"`
+	IncludeEmptyDirs    types.Bool     `tfsdk:"include_empty_dirs"`
+	RequiredFiles       []types.String `tfsdk:"required_files"`
+	AllowNotExist       types.Bool     `tfsdk:"allow_not_exist"`
+	OnConflict          types.String   `tfsdk:"on_conflict"`
+	Watch               []types.String `tfsdk:"watch"`
+	SourceHash          types.String   `tfsdk:"source_hash"`
 }
 
 func (r *FileResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -71,9 +105,6 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 		Attributes: map[string]schema.Attribute{
 			"base_dir": schema.StringAttribute{
 				Optional: true,
-				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplace(),
-				},
 			},
 			"sources": schema.ListAttribute{
 				ElementType: types.StringType,
@@ -83,9 +114,6 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 					listvalidator.SizeAtLeast(1),
 					listvalidator.ValueStringsAre(stringvalidator.LengthAtLeast(0)),
 				},
-				PlanModifiers: []planmodifier.List{
-					listplanmodifier.RequiresReplace(),
-				},
 			},
 			"contents": schema.MapAttribute{
 				ElementType: types.StringType,
@@ -94,9 +122,7 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 					mapvalidator.NoNullValues(),
 					mapvalidator.SizeAtLeast(1),
 					mapvalidator.ValueStringsAre(stringvalidator.LengthAtLeast(0)),
-				},
-				PlanModifiers: []planmodifier.Map{
-					mapplanmodifier.RequiresReplace(),
+					mapvalidator.KeysAre(entryNameValidator{}),
 				},
 			},
 			"excludes": schema.ListAttribute{
@@ -107,20 +133,25 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 					listvalidator.SizeAtLeast(1),
 					listvalidator.ValueStringsAre(stringvalidator.LengthAtLeast(1)),
 				},
-				PlanModifiers: []planmodifier.List{
-					listplanmodifier.RequiresReplace(),
-				},
 			},
 			"output": schema.StringAttribute{
 				Required: true,
-				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplace(),
-				},
 			},
 			"before_create": schema.StringAttribute{
 				Optional: true,
-				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplace(),
+			},
+			"interpreter": schema.ListAttribute{
+				ElementType: types.StringType,
+				Optional:    true,
+				Validators: []validator.List{
+					listvalidator.NoNullValues(),
+					listvalidator.SizeAtLeast(1),
+				},
+			},
+			"before_create_timeout": schema.StringAttribute{
+				Optional: true,
+				Validators: []validator.String{
+					durationValidator{},
 				},
 			},
 			"triggers": schema.MapAttribute{
@@ -131,9 +162,6 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 					mapvalidator.SizeAtLeast(1),
 					mapvalidator.ValueStringsAre(stringvalidator.LengthAtLeast(1)),
 				},
-				PlanModifiers: []planmodifier.Map{
-					mapplanmodifier.RequiresReplace(),
-				},
 			},
 			"base64sha256": schema.StringAttribute{
 				Computed: true,
@@ -147,6 +175,29 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 					stringplanmodifier.UseStateForUnknown(),
 				},
 			},
+			"content_sha256": schema.StringAttribute{
+				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"build_log": schema.StringAttribute{
+				Computed: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"source_hash": schema.StringAttribute{
+				Computed: true,
+			},
+			"watch": schema.ListAttribute{
+				ElementType: types.StringType,
+				Optional:    true,
+				Validators: []validator.List{
+					listvalidator.NoNullValues(),
+					listvalidator.SizeAtLeast(1),
+				},
+			},
 			"use_temp_dir": schema.BoolAttribute{
 				Optional: true,
 			},
@@ -164,6 +215,100 @@ func (r *FileResource) Schema(ctx context.Context, req resource.SchemaRequest, r
 					int32validator.AtLeast(1),
 				},
 			},
+			"default_file_mode": schema.StringAttribute{
+				Optional: true,
+				Validators: []validator.String{
+					stringvalidator.RegexMatches(fileModeRegexp, "must be an octal file mode (e.g. 0755)"),
+				},
+			},
+			"reproducible": schema.BoolAttribute{
+				Optional: true,
+			},
+			"source_date_epoch": schema.Int64Attribute{
+				Optional: true,
+				Validators: []validator.Int64{
+					int64validator.AtLeast(0),
+				},
+			},
+			"rebuild_if_missing": schema.BoolAttribute{
+				Optional: true,
+			},
+			"delete_output_on_destroy": schema.BoolAttribute{
+				Optional: true,
+			},
+			"symlinks": schema.StringAttribute{
+				Optional: true,
+				Validators: []validator.String{
+					stringvalidator.OneOf(
+						string(zip.SymlinkFollow),
+						string(zip.SymlinkPreserve),
+						string(zip.SymlinkError),
+					),
+				},
+			},
+			"include_empty_dirs": schema.BoolAttribute{
+				Optional: true,
+			},
+			"required_files": schema.ListAttribute{
+				ElementType: types.StringType,
+				Optional:    true,
+				Validators: []validator.List{
+					listvalidator.NoNullValues(),
+					listvalidator.SizeAtLeast(1),
+					listvalidator.ValueStringsAre(entryNameValidator{}),
+				},
+			},
+			"allow_not_exist": schema.BoolAttribute{
+				Optional: true,
+			},
+			"on_conflict": schema.StringAttribute{
+				Optional: true,
+				Validators: []validator.String{
+					stringvalidator.OneOf(
+						string(zip.ConflictError),
+						string(zip.ConflictSourceWins),
+						string(zip.ConflictContentsWins),
+					),
+				},
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"build": schema.ListNestedBlock{
+				NestedObject: schema.NestedBlockObject{
+					Attributes: map[string]schema.Attribute{
+						"command": schema.ListAttribute{
+							ElementType: types.StringType,
+							Required:    true,
+							Validators: []validator.List{
+								listvalidator.NoNullValues(),
+								listvalidator.SizeAtLeast(1),
+							},
+						},
+						"env": schema.MapAttribute{
+							ElementType: types.StringType,
+							Optional:    true,
+							Validators: []validator.Map{
+								mapvalidator.NoNullValues(),
+							},
+						},
+						"working_dir": schema.StringAttribute{
+							Optional: true,
+							Validators: []validator.String{
+								relativeDirValidator{},
+							},
+						},
+						"inherit_env": schema.BoolAttribute{
+							Optional: true,
+						},
+						"timeout": schema.StringAttribute{
+							Optional: true,
+							Validators: []validator.String{
+								durationValidator{},
+							},
+						},
+					},
+				},
+			},
 		},
 	}
 }
@@ -177,6 +322,30 @@ func (d *FileResource) ConfigValidators(_ context.Context) []resource.ConfigVali
 	}
 }
 
+func (r *FileResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var beforeCreate types.String
+	var interpreter types.List
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("before_create"), &beforeCreate)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("interpreter"), &interpreter)...)
+
+	// With an interpreter, before_create is a script that is not parsed.
+	if resp.Diagnostics.HasError() || beforeCreate.IsNull() || beforeCreate.IsUnknown() || !interpreter.IsNull() {
+		return
+	}
+
+	// Catch a command that would never run at plan time rather than failing
+	// (or silently doing nothing) during apply.
+	_, _, err := cmd.Parse(beforeCreate.ValueString())
+
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("before_create"),
+			"Invalid before_create",
+			fmt.Sprintf("`%s` will not run: %s", beforeCreate.ValueString(), err),
+		)
+	}
+}
+
 func (r *FileResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	var plan FileResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
@@ -185,163 +354,481 @@ func (r *FileResource) Create(ctx context.Context, req resource.CreateRequest, r
 		return
 	}
 
-	output := plan.Output.ValueString()
-	baseDir := plan.BaseDir.ValueString()
-	useTempDir := plan.UseTempDir.ValueBool()
-	compressionLevel := int(plan.CompressionLevel.ValueInt32())
-	stripComponents := int(plan.StripComponents.ValueInt32())
+	resp.Diagnostics.Append(r.buildAndHashSources(ctx, &plan)...)
 
-	// Build the zip under a lock. Everything in here depends on the process-wide
-	// working directory (os.Getwd, os.Chdir, cwd-relative glob and file reads),
-	// so it must not run concurrently with another resource's build. os.Getwd is
-	// read inside the lock so no other goroutine has chdir'd away, and the cwd is
-	// restored by deferred os.Chdir before the lock is released. Hashing and
-	// state writes below use the absolute output path and stay outside the lock.
-	func() {
-		chdirMu.Lock()
-		defer chdirMu.Unlock()
+	if resp.Diagnostics.HasError() {
+		return
+	}
 
-		cwd, err := os.Getwd()
+	resp.Diagnostics.Append(resp.State.Set(ctx, plan)...)
+}
 
-		if err != nil {
-			resp.Diagnostics.AddError("Failed to get current directory", err.Error())
+// buildAndHashSources builds the zip file and sets source_hash to data.
+func (r *FileResource) buildAndHashSources(ctx context.Context, data *FileResourceModel) diag.Diagnostics {
+	diags := r.build(ctx, data, false)
+
+	if diags.HasError() {
+		return diags
+	}
+
+	// Hash the sources as they are after the build, which is what the next
+	// plan compares against.
+	sourceHash, d := sourceHash(ctx, data)
+	diags.Append(d...)
+
+	if diags.HasError() {
+		return diags
+	}
+
+	data.SourceHash = types.StringValue(sourceHash)
+
+	return diags
+}
+
+// ModifyPlan plans an in-place rebuild when the inputs, or the files matched by
+// sources or watch, have changed since the zip file was built, so that
+// triggers do not have to repeat the patterns. When the zip file is going to
+// be built and is determined by its inputs alone, its hashes are planned too.
+func (r *FileResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// Nothing to do on destroy.
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	var plan FileResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !req.State.Raw.IsNull() {
+		var state FileResourceModel
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+
+		if resp.Diagnostics.HasError() {
 			return
 		}
 
-		// Restore cwd before the lock is released. A failed restore would leave
-		// the process in the wrong directory and break later operations, so it
-		// is reported instead of ignored.
-		restoreCwd := func() {
-			if err := os.Chdir(cwd); err != nil {
-				resp.Diagnostics.AddError("Failed to restore current working directory", err.Error())
+		resp.Diagnostics.Append(planSourceHash(ctx, &plan)...)
+
+		if resp.Diagnostics.HasError() {
+			return
+		}
+
+		// Update builds the zip file again. The hashes are left as they are
+		// otherwise, so that a change that does not affect the zip file does
+		// not show up in the resources using them.
+		if needsRebuild(&plan, &state) || sourcesChanged(&plan, &state) {
+			plan.Base64sha256 = types.StringUnknown()
+			plan.Base64md5 = types.StringUnknown()
+			plan.ContentSha256 = types.StringUnknown()
+			// The sources are hashed again after the build. They may be
+			// changed by the commands, or by other resources applied before
+			// this one (e.g. one that writes into base_dir and is referenced
+			// in triggers).
+			plan.SourceHash = types.StringUnknown()
+
+			if hasCommands(&plan) {
+				plan.BuildLog = types.StringUnknown()
+			} else {
+				plan.BuildLog = types.StringNull()
 			}
 		}
 
-		if !filepath.IsAbs(output) {
-			output = filepath.Join(cwd, output)
+		// An unknown value depends on another resource, which may change the
+		// sources when it is applied.
+		if !req.Config.Raw.IsFullyKnown() {
+			plan.SourceHash = types.StringUnknown()
 		}
 
-		if baseDir != "" {
-			err = os.Chdir(baseDir)
+		// The state of a zip file built before content_sha256 was added does
+		// not have it. Update reads it from the zip file.
+		if state.ContentSha256.IsNull() {
+			plan.ContentSha256 = types.StringUnknown()
+		}
+	}
 
-			if err != nil {
-				resp.Diagnostics.AddError("Failed to change current working directory", err.Error())
-				return
-			}
+	if plan.Base64sha256.IsUnknown() && isDeterministic(&plan) && req.Config.Raw.IsFullyKnown() {
+		r.planDigests(ctx, &plan)
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, plan)...)
+}
+
+// planSourceHash plans source_hash of an existing resource from the files as
+// they are now.
+func planSourceHash(ctx context.Context, plan *FileResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	// Unknown inputs rebuild the zip file anyway.
+	if plan.BaseDir.IsUnknown() || plan.Contents.IsUnknown() ||
+		hasUnknown(plan.Sources) || hasUnknown(plan.Excludes) || hasUnknown(plan.Watch) {
+		return diags
+	}
+
+	sourceHash, d := sourceHash(ctx, plan)
+	diags.Append(d...)
 
-			defer restoreCwd()
+	if diags.HasError() {
+		return diags
+	}
+
+	plan.SourceHash = types.StringValue(sourceHash)
+
+	return diags
+}
+
+// sourcesChanged reports whether the files matched by sources or watch, or
+// contents, have changed since the zip file was built. State written before
+// source_hash existed has nothing to compare with, so the zip file is built
+// again once rather than taking the files as they are now for the built ones.
+func sourcesChanged(plan *FileResourceModel, state *FileResourceModel) bool {
+	return state.SourceHash.IsNull() || !plan.SourceHash.Equal(state.SourceHash)
+}
+
+// needsRebuild reports whether the inputs that affect the zip file have
+// changed. Settings that only apply to refresh or destroy, and the checks of
+// the result, do not rebuild it.
+func needsRebuild(plan *FileResourceModel, state *FileResourceModel) bool {
+	return !plan.BaseDir.Equal(state.BaseDir) ||
+		!equalStrings(plan.Sources, state.Sources) ||
+		!plan.Contents.Equal(state.Contents) ||
+		!equalStrings(plan.Excludes, state.Excludes) ||
+		!plan.Output.Equal(state.Output) ||
+		!plan.BeforeCreate.Equal(state.BeforeCreate) ||
+		!equalStrings(plan.Interpreter, state.Interpreter) ||
+		!plan.Triggers.Equal(state.Triggers) ||
+		!plan.CompressionLevel.Equal(state.CompressionLevel) ||
+		!plan.StripComponents.Equal(state.StripComponents) ||
+		!plan.DefaultFileMode.Equal(state.DefaultFileMode) ||
+		!plan.Symlinks.Equal(state.Symlinks) ||
+		!plan.OnConflict.Equal(state.OnConflict) ||
+		plan.UseTempDir.ValueBool() != state.UseTempDir.ValueBool() ||
+		plan.Reproducible.ValueBool() != state.Reproducible.ValueBool() ||
+		!plan.SourceDateEpoch.Equal(state.SourceDateEpoch) ||
+		plan.IncludeEmptyDirs.ValueBool() != state.IncludeEmptyDirs.ValueBool() ||
+		!equalBuilds(plan.Build, state.Build)
+}
+
+// equalStrings reports whether a and b have the same values. A null list and
+// an empty one are equal.
+func equalStrings(a []types.String, b []types.String) bool {
+	return slices.EqualFunc(a, b, func(x, y types.String) bool { return x.Equal(y) })
+}
+
+// equalBuilds reports whether a and b run the same commands. State written
+// before build existed has null instead of an empty list, which is equal to
+// no build blocks. Timeouts do not change what the commands build.
+func equalBuilds(a []BuildModel, b []BuildModel) bool {
+	return slices.EqualFunc(a, b, func(x, y BuildModel) bool {
+		return equalStrings(x.Command, y.Command) &&
+			x.Env.Equal(y.Env) &&
+			x.WorkingDir.Equal(y.WorkingDir) &&
+			(x.InheritEnv.IsNull() || x.InheritEnv.ValueBool()) == (y.InheritEnv.IsNull() || y.InheritEnv.ValueBool())
+	})
+}
+
+// hasCommands reports whether any command runs before the zip file is built.
+func hasCommands(data *FileResourceModel) bool {
+	return !data.BeforeCreate.IsNull() || len(data.Build) >= 1
+}
+
+// isDeterministic reports whether the zip file described by data depends only
+// on its inputs: no command runs before it is built, and it is reproducible.
+func isDeterministic(data *FileResourceModel) bool {
+	return !hasCommands(data) && data.Reproducible.ValueBool()
+}
+
+// planDigests sets the hashes of the zip file that Create will build to data.
+// The zip file is not written. If it cannot be built yet, e.g. because its
+// sources are created by another resource during apply, the hashes are left
+// unknown and Create reports the error, if any. Terraform plans again before
+// Create and rejects hashes that differ from the saved plan.
+func (r *FileResource) planDigests(ctx context.Context, data *FileResourceModel) {
+	planned := *data
+	diags := r.build(ctx, &planned, true)
+
+	if diags.HasError() {
+		tflog.Debug(ctx, "Failed to plan the hashes of the zip file", map[string]any{
+			"output": data.Output.ValueString(),
+			"error":  diags.Errors()[0].Detail(),
+		})
+
+		return
+	}
+
+	data.Base64sha256 = planned.Base64sha256
+	data.Base64md5 = planned.Base64md5
+	data.ContentSha256 = planned.ContentSha256
+}
+
+// build creates the zip file described by data and sets its hashes to data.
+// With dryRun, the hashes are computed without writing the zip file, which is
+// only meaningful when there are no commands to run (see isDeterministic).
+func (r *FileResource) build(ctx context.Context, data *FileResourceModel, dryRun bool) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	output := data.Output.ValueString()
+	baseDir := data.BaseDir.ValueString()
+	useTempDir := data.UseTempDir.ValueBool()
+	compressionLevel := int(data.CompressionLevel.ValueInt32())
+	stripComponents := int(data.StripComponents.ValueInt32())
+	zipOpts := []zip.Option{}
+
+	if defaultFileMode := data.DefaultFileMode.ValueString(); defaultFileMode != "" {
+		mode, err := strconv.ParseUint(defaultFileMode, 8, 32)
+
+		if err != nil {
+			diags.AddError("Failed to parse default_file_mode", err.Error())
+			return diags
 		}
 
-		if useTempDir {
-			tempDir, err := os.MkdirTemp("", "lambdazip")
+		zipOpts = append(zipOpts, zip.WithDefaultFileMode(fs.FileMode(mode)))
+	}
 
-			if err != nil {
-				resp.Diagnostics.AddError("Failed to create temporary directory", err.Error())
-				return
-			}
+	if data.Reproducible.ValueBool() {
+		// Unlike SOURCE_DATE_EPOCH of the provider process, source_date_epoch
+		// is recorded in the state, so it is the same on every machine.
+		epoch := time.Unix(data.SourceDateEpoch.ValueInt64(), 0).UTC()
 
-			defer os.RemoveAll(tempDir)
-			err = cp.Copy(".", tempDir)
+		if data.SourceDateEpoch.IsNull() {
+			var err error
+			epoch, err = zip.SourceDateEpoch()
 
 			if err != nil {
-				resp.Diagnostics.AddError("Failed to copy files to temporary directory", err.Error())
-				return
+				diags.AddError("Failed to get modification time for reproducible zip", err.Error())
+				return diags
 			}
+		}
 
-			err = os.Chdir(tempDir)
+		zipOpts = append(zipOpts, zip.WithReproducible(epoch))
+	}
 
-			if err != nil {
-				resp.Diagnostics.AddError("Failed to change current working directory", err.Error())
-				return
-			}
+	allowNotExist := data.AllowNotExist.ValueBool()
+	displayBaseDir := "."
+
+	if baseDir != "" {
+		displayBaseDir = baseDir
+	}
+
+	if !allowNotExist {
+		zipOpts = append(zipOpts, zip.WithFailOnEmpty())
+	}
+
+	if v := data.OnConflict.ValueString(); v != "" {
+		zipOpts = append(zipOpts, zip.WithOnConflict(zip.ConflictPolicy(v)))
+	}
+
+	symlinks := symlinkMode(data)
+	globOpts := symlinkGlobOptions(symlinks)
+	zipOpts = append(zipOpts, zip.WithSymlinks(symlinks))
+
+	// Links out of base_dir are legitimate (e.g. workspace packages linked
+	// into node_modules), but may add files that were not meant to be
+	// shipped.
+	zipOpts = append(zipOpts, zip.WithOnOutsideLink(func(path string, target string) {
+		summary := "Symbolic link points outside base_dir"
+		detail := fmt.Sprintf("%s is a symbolic link to %s, which is added to the zip file. Set symlinks = \"preserve\" or \"error\" to reject it.", path, target)
+		diags.AddWarning(summary, detail)
+	}))
+
+	// Everything below is rooted at an explicit directory instead of the
+	// process-wide working directory, so builds of different resources can run
+	// concurrently.
+	root := "."
 
-			defer restoreCwd()
+	if baseDir != "" {
+		root = baseDir
+	}
+
+	// Without commands, nothing is written to root.
+	if useTempDir && !dryRun {
+		tempDir, err := os.MkdirTemp("", "lambdazip")
+
+		if err != nil {
+			diags.AddError("Failed to create temporary directory", err.Error())
+			return diags
 		}
 
-		sources := []string{}
+		defer os.RemoveAll(tempDir)
+		err = cp.Copy(root, tempDir)
 
-		if len(plan.Sources) >= 1 {
-			for _, pat := range plan.Sources {
-				sources = append(sources, pat.ValueString())
-			}
+		if err != nil {
+			diags.AddError("Failed to copy files to temporary directory", err.Error())
+			return diags
+		}
 
-			excludes := []string{}
+		root = tempDir
+	}
 
-			for _, pat := range plan.Excludes {
-				excludes = append(excludes, pat.ValueString())
-			}
+	// Output of the commands is logged with the zip file it is built for.
+	ctx = tflog.SetField(ctx, "output", output)
+	var buildLog strings.Builder
 
-			if beforeCreate := plan.BeforeCreate.ValueString(); beforeCreate != "" {
-				cmdout, err := cmd.Run(beforeCreate)
+	// before_create runs whether the archive is built from sources, contents
+	// or both, since contents may be read from files it generates.
+	if !data.BeforeCreate.IsNull() {
+		beforeCreate := data.BeforeCreate.ValueString()
+		interpreter := []string{}
 
-				if err != nil {
-					cmdout = strings.TrimSpace(cmdout)
+		for _, arg := range data.Interpreter {
+			interpreter = append(interpreter, arg.ValueString())
+		}
 
-					if cmdout == "" {
-						cmdout = "(empty)"
-					}
+		c, err := cmd.ParseCommand(root, interpreter, beforeCreate)
 
-					summary := fmt.Sprintf("Failed to run `%s`", beforeCreate)
-					detail := fmt.Sprintf("%s\noutput: %s", err, cmdout)
-					resp.Diagnostics.AddError(summary, detail)
-					return
-				}
-			}
+		if err != nil {
+			diags.AddError(fmt.Sprintf("Failed to run `%s`", beforeCreate), err.Error())
+			return diags
+		}
 
-			sources, err = glob.Glob(sources, excludes)
+		diags.Append(runCommand(ctx, c, data.BeforeCreateTimeout, "before_create", beforeCreate, &buildLog)...)
 
-			if err != nil {
-				resp.Diagnostics.AddError("Failed to glob files", err.Error())
-				return
-			}
+		if diags.HasError() {
+			return diags
+		}
+	}
+
+	for i, b := range data.Build {
+		c := &cmd.Command{
+			Dir:        filepath.Join(root, b.WorkingDir.ValueString()),
+			InheritEnv: b.InheritEnv.IsNull() || b.InheritEnv.ValueBool(),
+		}
+
+		for _, arg := range b.Command {
+			c.Args = append(c.Args, arg.ValueString())
 		}
 
-		contents := map[string]string{}
+		env := make(map[string]types.String, len(b.Env.Elements()))
+		diags.Append(b.Env.ElementsAs(ctx, &env, false)...)
+
+		if diags.HasError() {
+			return diags
+		}
+
+		for key, value := range env {
+			c.Env = append(c.Env, key+"="+value.ValueString())
+		}
+
+		// Sort for a stable environment; keys in a map are unique anyway.
+		sort.Strings(c.Env)
+
+		step := fmt.Sprintf("build[%d]", i)
+		diags.Append(runCommand(ctx, c, b.Timeout, step, strings.Join(c.Args, " "), &buildLog)...)
 
-		if len(plan.Contents.Elements()) >= 1 {
-			elements := make(map[string]types.String, len(plan.Contents.Elements()))
-			resp.Diagnostics.Append(plan.Contents.ElementsAs(ctx, &elements, false)...)
+		if diags.HasError() {
+			return diags
+		}
+	}
+
+	sources := []string{}
+
+	if len(data.Sources) >= 1 {
+		for _, pat := range data.Sources {
+			sources = append(sources, pat.ValueString())
+		}
+
+		excludes := []string{}
+
+		for _, pat := range data.Excludes {
+			excludes = append(excludes, pat.ValueString())
+		}
+
+		matched, err := glob.Match(root, sources, excludes, globOpts...)
+
+		if err != nil {
+			diags.AddError("Failed to glob files", err.Error())
+			return diags
+		}
 
-			if resp.Diagnostics.HasError() {
-				return
+		// A pattern that matches nothing is most likely a typo or a missing
+		// build output, which would otherwise ship a broken zip file.
+		if !allowNotExist {
+			unmatched := matched.Unmatched
+
+			// An empty directory is an entry of its own with include_empty_dirs.
+			if !data.IncludeEmptyDirs.ValueBool() {
+				unmatched = append(unmatched, matched.DirsOnly...)
+			}
+
+			for _, pat := range unmatched {
+				summary := "Source pattern matches no files"
+				detail := fmt.Sprintf("`%s` matches no files in %s. Set allow_not_exist = true to allow it.", pat, displayBaseDir)
+				diags.AddError(summary, detail)
 			}
 
-			for name, data := range elements {
-				contents[name] = data.ValueString()
+			if diags.HasError() {
+				return diags
 			}
 		}
 
-		err = zip.ZipFile(sources, contents, output, compressionLevel, stripComponents)
+		sources = matched.Files
 
-		if err != nil {
-			resp.Diagnostics.AddError("Failed to zip files", err.Error())
-			return
+		// Directories with files are created on extraction anyway, so only
+		// empty ones need entries of their own.
+		if data.IncludeEmptyDirs.ValueBool() {
+			sources = append(sources, glob.EmptyDirs(matched.Files, matched.Dirs)...)
+			sort.Strings(sources)
 		}
-	}()
+	}
 
-	if resp.Diagnostics.HasError() {
-		return
+	contents := map[string]string{}
+
+	if len(data.Contents.Elements()) >= 1 {
+		elements := make(map[string]types.String, len(data.Contents.Elements()))
+		diags.Append(data.Contents.ElementsAs(ctx, &elements, false)...)
+
+		if diags.HasError() {
+			return diags
+		}
+
+		for name, value := range elements {
+			contents[name] = value.ValueString()
+		}
 	}
 
-	base64sha256, err := hash.Base64Sha256(output)
+	if len(data.RequiredFiles) >= 1 {
+		required := []string{}
 
-	if err != nil {
-		resp.Diagnostics.AddError("Failed to calculate sha256sum", err.Error())
-		return
+		for _, name := range data.RequiredFiles {
+			required = append(required, name.ValueString())
+		}
+
+		diags.Append(checkRequiredFiles(root, required, sources, contents, stripComponents)...)
+
+		if diags.HasError() {
+			return diags
+		}
 	}
 
-	plan.Base64sha256 = types.StringValue(base64sha256)
+	var digests *zip.Digests
+	var err error
 
-	base64md5, err := hash.Base64Md5(output)
+	if dryRun {
+		digests, err = zip.Digest(root, sources, contents, compressionLevel, stripComponents, zipOpts...)
+	} else {
+		digests, err = zip.ZipFile(root, sources, contents, output, compressionLevel, stripComponents, zipOpts...)
+	}
 
-	if err != nil {
-		resp.Diagnostics.AddError("Failed to calculate md5sum", err.Error())
-		return
+	if errors.Is(err, zip.ErrEmpty) {
+		diags.AddError("Failed to zip files", fmt.Sprintf("%s. Set allow_not_exist = true to allow an empty zip file.", err))
+		return diags
+	} else if err != nil {
+		diags.AddError("Failed to zip files", err.Error())
+		return diags
 	}
 
-	plan.Base64md5 = types.StringValue(base64md5)
+	data.Base64sha256 = types.StringValue(digests.Base64Sha256)
+	data.Base64md5 = types.StringValue(digests.Base64Md5)
+	data.ContentSha256 = types.StringValue(digests.ContentSha256)
+	data.BuildLog = types.StringNull()
 
-	resp.Diagnostics.Append(resp.State.Set(ctx, plan)...)
+	if buildLog.Len() > 0 {
+		data.BuildLog = types.StringValue(truncateLog(buildLog.String(), maxBuildLogSize))
+	}
+
+	return diags
 }
 
 func (r *FileResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
@@ -352,17 +839,107 @@ func (r *FileResource) Read(ctx context.Context, req resource.ReadRequest, resp
 		return
 	}
 
+	// Remove the resource from the state if the zip file has been deleted or
+	// overwritten since it was created, so that it is created again instead of
+	// passing a stale base64sha256 to the Lambda function.
+	output := state.Output.ValueString()
+	base64sha256, err := hash.Base64Sha256(output)
+
+	if errors.Is(err, fs.ErrNotExist) && state.RebuildIfMissing.ValueBool() {
+		// The zip file is missing on a fresh checkout (e.g. an ephemeral CI
+		// runner). Rebuild it from the recorded inputs and keep the state as is
+		// if the result is the same as before.
+		rebuilt := state
+		resp.Diagnostics.Append(r.build(ctx, &rebuilt, false)...)
+
+		if resp.Diagnostics.HasError() {
+			return
+		}
+
+		if rebuilt.Base64sha256.Equal(state.Base64sha256) {
+			resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+			return
+		}
+
+		summary := "Rebuilt output file has been changed"
+		detail := fmt.Sprintf("%s was missing and has been rebuilt, but its content differs from the state (base64sha256 in state: %s, actual: %s). It will be created again.", output, state.Base64sha256.ValueString(), rebuilt.Base64sha256.ValueString())
+		resp.Diagnostics.AddWarning(summary, detail)
+		resp.State.RemoveResource(ctx)
+		return
+	} else if errors.Is(err, fs.ErrNotExist) {
+		summary := "Output file does not exist"
+		detail := fmt.Sprintf("%s does not exist (base64sha256 in state: %s). It will be created again.", output, state.Base64sha256.ValueString())
+		resp.Diagnostics.AddWarning(summary, detail)
+		resp.State.RemoveResource(ctx)
+		return
+	} else if err != nil {
+		resp.Diagnostics.AddError("Failed to calculate sha256sum", err.Error())
+		return
+	}
+
+	if base64sha256 != state.Base64sha256.ValueString() {
+		summary := "Output file has been changed"
+		detail := fmt.Sprintf("%s has been changed outside of Terraform (base64sha256 in state: %s, actual: %s). It will be created again.", output, state.Base64sha256.ValueString(), base64sha256)
+		resp.Diagnostics.AddWarning(summary, detail)
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
 
 func (r *FileResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
-	var plan FileResourceModel
+	var plan, state FileResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
 
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	// ModifyPlan plans the hashes as unknown, or as those of a deterministic
+	// build, when the zip file is built again.
+	if needsRebuild(&plan, &state) || !plan.Base64sha256.Equal(state.Base64sha256) {
+		resp.Diagnostics.Append(r.buildAndHashSources(ctx, &plan)...)
+
+		if resp.Diagnostics.HasError() {
+			return
+		}
+
+		// The zip file has been moved to a new output, so the previous one
+		// would otherwise be left behind.
+		if plan.DeleteOutput.ValueBool() && filepath.Clean(plan.Output.ValueString()) != filepath.Clean(state.Output.ValueString()) {
+			resp.Diagnostics.Append(deleteOutput(&state)...)
+		}
+
+		resp.Diagnostics.Append(resp.State.Set(ctx, plan)...)
+		return
+	}
+
+	if plan.SourceHash.IsUnknown() {
+		sourceHash, diags := sourceHash(ctx, &plan)
+		resp.Diagnostics.Append(diags...)
+
+		if resp.Diagnostics.HasError() {
+			return
+		}
+
+		plan.SourceHash = types.StringValue(sourceHash)
+	}
+
+	// Planned as unknown for the state of a zip file built before
+	// content_sha256 was added.
+	if plan.ContentSha256.IsUnknown() {
+		contentSha256, err := zip.ContentSha256(plan.Output.ValueString())
+
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to calculate content sha256", err.Error())
+			return
+		}
+
+		plan.ContentSha256 = types.StringValue(contentSha256)
+	}
+
 	resp.Diagnostics.Append(resp.State.Set(ctx, plan)...)
 }
 
@@ -374,5 +951,323 @@ func (r *FileResource) Delete(ctx context.Context, req resource.DeleteRequest, r
 		return
 	}
 
+	if state.DeleteOutput.ValueBool() {
+		resp.Diagnostics.Append(deleteOutput(&state)...)
+
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
 	resp.State.RemoveResource(ctx)
 }
+
+// sourceHash returns a digest of the files matched by sources and watch, and
+// of contents.
+func sourceHash(ctx context.Context, data *FileResourceModel) (string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	root := "."
+
+	if baseDir := data.BaseDir.ValueString(); baseDir != "" {
+		root = baseDir
+	}
+
+	maps := map[string]map[string]string{}
+	symlinks := symlinkMode(data)
+	globOpts := symlinkGlobOptions(symlinks)
+
+	// Patterns that match nothing are not an error here, since the files may
+	// be created by before_create or build.
+	for key, patterns := range map[string][]types.String{"sources": data.Sources, "watch": data.Watch} {
+		if len(patterns) == 0 {
+			continue
+		}
+
+		excludes := []string{}
+
+		if key == "sources" {
+			excludes = valueStrings(data.Excludes)
+		}
+
+		matched, err := glob.Match(root, valueStrings(patterns), excludes, globOpts...)
+
+		if err != nil {
+			diags.AddError(fmt.Sprintf("Failed to glob %s", key), err.Error())
+			return "", diags
+		}
+
+		files := matched.Files
+
+		// A link that is not followed is stored as it is, and its target may
+		// not exist.
+		if symlinks == zip.SymlinkFollow {
+			maps[key], err = hash.Sha256Map(root, files)
+		} else {
+			maps[key], err = hash.Sha256MapNoFollow(root, files)
+		}
+
+		if err != nil {
+			diags.AddError(fmt.Sprintf("Failed to calculate sha256sum of %s", key), err.Error())
+			return "", diags
+		}
+	}
+
+	if len(data.Contents.Elements()) >= 1 {
+		elements := make(map[string]string, len(data.Contents.Elements()))
+		diags.Append(data.Contents.ElementsAs(ctx, &elements, false)...)
+
+		if diags.HasError() {
+			return "", diags
+		}
+
+		maps["contents"] = hash.ContentsSha256Map(elements)
+	}
+
+	return hash.Base64Sha256Maps(maps), diags
+}
+
+// symlinkMode returns how symbolic links in the sources are stored.
+func symlinkMode(data *FileResourceModel) zip.SymlinkMode {
+	if v := data.Symlinks.ValueString(); v != "" {
+		return zip.SymlinkMode(v)
+	}
+
+	return zip.SymlinkFollow
+}
+
+// symlinkGlobOptions returns the options to match the sources with, so that
+// links that are not followed are matched as files.
+func symlinkGlobOptions(symlinks zip.SymlinkMode) []doublestar.GlobOption {
+	if symlinks == zip.SymlinkFollow {
+		return nil
+	}
+
+	return []doublestar.GlobOption{doublestar.WithNoFollow()}
+}
+
+func valueStrings(values []types.String) []string {
+	strs := []string{}
+
+	for _, v := range values {
+		strs = append(strs, v.ValueString())
+	}
+
+	return strs
+}
+
+func hasUnknown(values []types.String) bool {
+	for _, v := range values {
+		if v.IsUnknown() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runCommand runs c, killing it when ctx is canceled or timeout elapses. Each
+// line of the output is logged as it is written, and the whole output is
+// appended to log under name. A failure is reported with the output.
+func runCommand(ctx context.Context, c *cmd.Command, timeout types.String, step string, name string, log *strings.Builder) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	if v := timeout.ValueString(); v != "" {
+		d, err := time.ParseDuration(v)
+
+		if err != nil {
+			diags.AddError("Failed to parse timeout", err.Error())
+			return diags
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	ctx = tflog.SetField(ctx, "step", step)
+
+	c.Log = func(line string) {
+		tflog.Info(ctx, line)
+	}
+
+	cmdout, err := c.Run(ctx)
+	fmt.Fprintf(log, "$ %s\n%s", name, cmdout)
+
+	if err != nil {
+		cmdout = strings.TrimSpace(cmdout)
+
+		if cmdout == "" {
+			cmdout = "(empty)"
+		}
+
+		summary := fmt.Sprintf("Failed to run `%s`", name)
+		detail := fmt.Sprintf("%s\noutput: %s", err, cmdout)
+		diags.AddError(summary, detail)
+	}
+
+	return diags
+}
+
+// checkRequiredFiles reports the required entries that are not in the zip
+// file built from sources and contents with strip leading components removed.
+// A required entry is reported as not matched if it exists under root after
+// the build commands have run, i.e. is excluded or not matched by sources.
+func checkRequiredFiles(root string, required []string, sources []string, contents map[string]string, strip int) diag.Diagnostics {
+	var diags diag.Diagnostics
+	entrySet := map[string]struct{}{}
+	entries := []string{}
+
+	for _, name := range slices.Concat(sources, slices.Sorted(maps.Keys(contents))) {
+		entryName, err := zip.EntryName(name, strip)
+
+		// Zip reports an unsafe name with the rest of the entries.
+		if err != nil || entryName == "" {
+			continue
+		}
+
+		if _, ok := entrySet[entryName]; !ok {
+			entrySet[entryName] = struct{}{}
+			entries = append(entries, entryName)
+		}
+	}
+
+	missing := []string{}
+
+	for _, name := range required {
+		// Validated by entryNameValidator.
+		name, _ = zip.NormalizeName(name)
+
+		if _, ok := entrySet[name]; ok {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (does not exist)", name))
+		} else {
+			missing = append(missing, fmt.Sprintf("%s (not matched by sources)", name))
+		}
+	}
+
+	if len(missing) == 0 {
+		return diags
+	}
+
+	// List what the zip file would contain instead, which is usually enough to
+	// see why, e.g. a binary built for another path or GOOS.
+	const maxListed = 20
+	listed := entries
+
+	if len(listed) > maxListed {
+		listed = append(listed[:maxListed:maxListed], fmt.Sprintf("... and %d more", len(entries)-maxListed))
+	}
+
+	if len(listed) == 0 {
+		listed = []string{"(nothing)"}
+	}
+
+	detail := fmt.Sprintf("missing:\n  %s\nmatched:\n  %s", strings.Join(missing, "\n  "), strings.Join(listed, "\n  "))
+	diags.AddError("Required files are missing", detail)
+
+	return diags
+}
+
+// truncateLog returns the last limit bytes of log, starting at a line (or at a
+// character if no line starts within them), so that build_log keeps the end of
+// the output where failures and summaries are.
+func truncateLog(log string, limit int) string {
+	if len(log) <= limit {
+		return log
+	}
+
+	log = log[len(log)-limit:]
+
+	if i := strings.IndexByte(log, '\n'); i >= 0 && i < len(log)-1 {
+		log = log[i+1:]
+	} else {
+		// Without a line to start at, start at a character so that the state
+		// is valid UTF-8.
+		for len(log) > 0 && !utf8.RuneStart(log[0]) {
+			log = log[1:]
+		}
+	}
+
+	return "(truncated)\n" + log
+}
+
+// deleteOutput removes the zip file created by the resource. It refuses to
+// remove a file outside the working directory and base_dir, and leaves the
+// file alone if it has been replaced by another build (e.g. by the new
+// resource of create_before_destroy writing to the same output).
+func deleteOutput(state *FileResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	output := state.Output.ValueString()
+
+	absOutput, err := filepath.Abs(output)
+
+	if err != nil {
+		diags.AddError("Failed to get absolute path of output", err.Error())
+		return diags
+	}
+
+	cwd, err := os.Getwd()
+
+	if err != nil {
+		diags.AddError("Failed to get current directory", err.Error())
+		return diags
+	}
+
+	allowed := isWithin(cwd, absOutput)
+
+	if baseDir := state.BaseDir.ValueString(); baseDir != "" {
+		absBaseDir, err := filepath.Abs(baseDir)
+
+		if err != nil {
+			diags.AddError("Failed to get absolute path of base_dir", err.Error())
+			return diags
+		}
+
+		allowed = allowed || isWithin(absBaseDir, absOutput)
+	}
+
+	if !allowed {
+		summary := "Refused to delete output file"
+		detail := fmt.Sprintf("%s is outside the working directory and base_dir.", output)
+		diags.AddError(summary, detail)
+		return diags
+	}
+
+	base64sha256, err := hash.Base64Sha256(absOutput)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return diags
+	} else if err != nil {
+		diags.AddError("Failed to calculate sha256sum", err.Error())
+		return diags
+	}
+
+	if base64sha256 != state.Base64sha256.ValueString() {
+		summary := "Output file was not deleted"
+		detail := fmt.Sprintf("%s has been changed since it was created (base64sha256 in state: %s, actual: %s), so it was left in place.", output, state.Base64sha256.ValueString(), base64sha256)
+		diags.AddWarning(summary, detail)
+		return diags
+	}
+
+	err = os.Remove(absOutput)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		diags.AddError("Failed to delete output file", err.Error())
+	}
+
+	return diags
+}
+
+// isWithin reports whether name is inside dir. Both must be absolute.
+func isWithin(dir string, name string) bool {
+	rel, err := filepath.Rel(dir, name)
+
+	if err != nil {
+		return false
+	}
+
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -1,12 +1,21 @@
 package provider_test
 
 import (
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/knownvalue"
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
 	"github.com/hashicorp/terraform-plugin-testing/terraform"
+	"github.com/hashicorp/terraform-plugin-testing/tfjsonpath"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -56,12 +65,12 @@ func TestFiles_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create", "touch exec.txt"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "a6p1iUHprlLD6/MDM7kHa9dhCuOmcPiBmU+JShrJ4Ro="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "71XQ6nxelFFDOT2Z911yeA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "vNpfn22C6CepPQFDAl4PiTsx0ScD+GZEfJtcdgqmEw8="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "s5QM3YbHUQnjCu6A4F8yzQ=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("a6p1iUHprlLD6/MDM7kHa9dhCuOmcPiBmU+JShrJ4Ro=", base64Sha256(buf))
+						assert.Equal("vNpfn22C6CepPQFDAl4PiTsx0ScD+GZEfJtcdgqmEw8=", base64Sha256(buf))
 						assert.True(isFileExists("app/exec.txt"))
 						list, err := listZip(buf)
 						require.NoError(err)
@@ -102,11 +111,13 @@ func TestFiles_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create", "touch exec.txt"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "a6p1iUHprlLD6/MDM7kHa9dhCuOmcPiBmU+JShrJ4Ro="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "71XQ6nxelFFDOT2Z911yeA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "vNpfn22C6CepPQFDAl4PiTsx0ScD+GZEfJtcdgqmEw8="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "s5QM3YbHUQnjCu6A4F8yzQ=="),
 					func(*terraform.State) error {
-						assert.False(isFileExists("my-app.zip"))
-						assert.False(isFileExists("app/exec.txt"))
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal("vNpfn22C6CepPQFDAl4PiTsx0ScD+GZEfJtcdgqmEw8=", base64Sha256(buf))
+						assert.True(isFileExists("app/exec.txt"))
 						return nil
 					},
 				),
@@ -141,12 +152,12 @@ func TestFiles_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create", "touch exec.txt"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "6740287d0049734d6fe501a11d8572ba1befdc690d08d891db539d2f8a9d7273"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "55024qLWGJdd9NUOXG8Y/4xpeWuckpVC9VJE1ZZPQtA="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "PZ4LSRZZ19Znln0I4hFEAA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "DrxbZnots36Pvp9UG5LQVvlS2KnqGvt43h7XOeEi6G0="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "swsmpd8708qIdaCqehVfQQ=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("55024qLWGJdd9NUOXG8Y/4xpeWuckpVC9VJE1ZZPQtA=", base64Sha256(buf))
+						assert.Equal("DrxbZnots36Pvp9UG5LQVvlS2KnqGvt43h7XOeEi6G0=", base64Sha256(buf))
 						assert.True(isFileExists("app/exec.txt"))
 						list, err := listZip(buf)
 						require.NoError(err)
@@ -171,9 +182,7 @@ func TestFiles_basic(t *testing.T) {
 					}
 				`,
 				PreConfig: func() {
-					err := os.Remove("my-app.zip")
-					require.NoError(err)
-					err = os.Remove("app/exec.txt")
+					err := os.Remove("app/exec.txt")
 					require.NoError(err)
 					err = os.WriteFile("app/world.rb", []byte("print 'hello'"), 0755)
 					require.NoError(err)
@@ -191,11 +200,16 @@ func TestFiles_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create", "touch exec.txt"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "6740287d0049734d6fe501a11d8572ba1befdc690d08d891db539d2f8a9d7273"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "55024qLWGJdd9NUOXG8Y/4xpeWuckpVC9VJE1ZZPQtA="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "PZ4LSRZZ19Znln0I4hFEAA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "edyxv0LhmD50oQEmD71S/MMoSOeUj14qRtJ/mLBBxZw="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "lyKcHR1UZDXs6xvghsntCw=="),
 					func(*terraform.State) error {
-						assert.False(isFileExists("my-app.zip"))
-						assert.False(isFileExists("app/exec.txt"))
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal("edyxv0LhmD50oQEmD71S/MMoSOeUj14qRtJ/mLBBxZw=", base64Sha256(buf))
+						assert.True(isFileExists("app/exec.txt"))
+						list, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"hello.rb", "world.rb"}, list)
 						return nil
 					},
 				),
@@ -250,12 +264,12 @@ func TestFiles_bestCompression(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create", "touch exec.txt"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "9"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "a6p1iUHprlLD6/MDM7kHa9dhCuOmcPiBmU+JShrJ4Ro="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "71XQ6nxelFFDOT2Z911yeA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "vNpfn22C6CepPQFDAl4PiTsx0ScD+GZEfJtcdgqmEw8="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "s5QM3YbHUQnjCu6A4F8yzQ=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("a6p1iUHprlLD6/MDM7kHa9dhCuOmcPiBmU+JShrJ4Ro=", base64Sha256(buf))
+						assert.Equal("vNpfn22C6CepPQFDAl4PiTsx0ScD+GZEfJtcdgqmEw8=", base64Sha256(buf))
 						assert.True(isFileExists("app/exec.txt"))
 						list, err := listZip(buf)
 						require.NoError(err)
@@ -310,12 +324,12 @@ func TestContents_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "contents.app/lib/const.rb", "A = 100"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "output", "my-app.zip"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "3RvIR+rYfQjlytlx/hGPe2drWNSu59499c3uAKz6Kh4="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "a9+xgv4ENWPVe3EyG0cf+g=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "kiT7BQ0cz90obVTumr9Y9sfLnrokY8w1kRi1qhpOXLE="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "O579nkydyKVp793WtIL2Mw=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("3RvIR+rYfQjlytlx/hGPe2drWNSu59499c3uAKz6Kh4=", base64Sha256(buf))
+						assert.Equal("kiT7BQ0cz90obVTumr9Y9sfLnrokY8w1kRi1qhpOXLE=", base64Sha256(buf))
 						list, err := listZip(buf)
 						require.NoError(err)
 						assert.Equal([]string{"app/README.md", "app/hello.rb", "app/lib/const.rb", "app/world.rb"}, list)
@@ -347,12 +361,12 @@ func TestContents_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "contents.app/lib/const.rb", "A = 100"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "output", "my-app.zip"),
 					resource.TestCheckNoResourceAttr("lambdazip_file.my_app", "triggers"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "3RvIR+rYfQjlytlx/hGPe2drWNSu59499c3uAKz6Kh4="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "a9+xgv4ENWPVe3EyG0cf+g=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "kiT7BQ0cz90obVTumr9Y9sfLnrokY8w1kRi1qhpOXLE="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "O579nkydyKVp793WtIL2Mw=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("3RvIR+rYfQjlytlx/hGPe2drWNSu59499c3uAKz6Kh4=", base64Sha256(buf))
+						assert.Equal("kiT7BQ0cz90obVTumr9Y9sfLnrokY8w1kRi1qhpOXLE=", base64Sha256(buf))
 						list, err := listZip(buf)
 						require.NoError(err)
 						assert.Equal([]string{"app/README.md", "app/hello.rb", "app/lib/const.rb", "app/world.rb"}, list)
@@ -412,12 +426,12 @@ func TestFiles_tempDir(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "use_temp_dir", "true"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "3mKBu6qleIlPZxj0rzLcS52hB1p0K2L76H9MZb+h2kk="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "xv2nLPh7HCogSMWKZ+Lkog=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "lTzsHfjRMmiSB72mBrI9CSUiLsjOm231a1vPObtCH44="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "TTRrzuW6MYPHwcqu/uvzmg=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("3mKBu6qleIlPZxj0rzLcS52hB1p0K2L76H9MZb+h2kk=", base64Sha256(buf))
+						assert.Equal("lTzsHfjRMmiSB72mBrI9CSUiLsjOm231a1vPObtCH44=", base64Sha256(buf))
 						assert.False(isFileExists("app/exec.txt"))
 						list, err := listZip(buf)
 						require.NoError(err)
@@ -459,10 +473,12 @@ func TestFiles_tempDir(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "use_temp_dir", "true"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "3mKBu6qleIlPZxj0rzLcS52hB1p0K2L76H9MZb+h2kk="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "xv2nLPh7HCogSMWKZ+Lkog=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "lTzsHfjRMmiSB72mBrI9CSUiLsjOm231a1vPObtCH44="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "TTRrzuW6MYPHwcqu/uvzmg=="),
 					func(*terraform.State) error {
-						assert.False(isFileExists("my-app.zip"))
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal("lTzsHfjRMmiSB72mBrI9CSUiLsjOm231a1vPObtCH44=", base64Sha256(buf))
 						assert.False(isFileExists("app/exec.txt"))
 						return nil
 					},
@@ -501,12 +517,12 @@ func TestFiles_tempDir(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "use_temp_dir", "true"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "6740287d0049734d6fe501a11d8572ba1befdc690d08d891db539d2f8a9d7273"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "mR/NIFGb1EjnvdQWtPZTA3jnrDUGo/rPX++UGs1G9XI="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "QPWvDtIecJEW7s1KWh/stA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "ZBP/GgvOpZk+5mtO8QI6SLiZXAInosuTc0QSFgQJEdU="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "QPwDi3KP3p5gfLLPjR7dJQ=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("mR/NIFGb1EjnvdQWtPZTA3jnrDUGo/rPX++UGs1G9XI=", base64Sha256(buf))
+						assert.Equal("ZBP/GgvOpZk+5mtO8QI6SLiZXAInosuTc0QSFgQJEdU=", base64Sha256(buf))
 						assert.False(isFileExists("app/exec.txt"))
 						list, err := listZip(buf)
 						require.NoError(err)
@@ -532,9 +548,7 @@ func TestFiles_tempDir(t *testing.T) {
 					}
 				`,
 				PreConfig: func() {
-					err := os.Remove("my-app.zip")
-					require.NoError(err)
-					err = os.WriteFile("app/world.rb", []byte("print 'hello'"), 0755)
+					err := os.WriteFile("app/world.rb", []byte("print 'hello'"), 0755)
 					require.NoError(err)
 					err = os.Remove("app/lib/const.rb")
 					require.NoError(err)
@@ -552,11 +566,16 @@ func TestFiles_tempDir(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "use_temp_dir", "true"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "6740287d0049734d6fe501a11d8572ba1befdc690d08d891db539d2f8a9d7273"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "mR/NIFGb1EjnvdQWtPZTA3jnrDUGo/rPX++UGs1G9XI="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "QPWvDtIecJEW7s1KWh/stA=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "L2KobC9pELzJB5R3x9Fw0WiT/WVnyhJhYytNncmZJYU="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "DPkVt9xOonyUS6zzBCU4/Q=="),
 					func(*terraform.State) error {
-						assert.False(isFileExists("my-app.zip"))
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal("L2KobC9pELzJB5R3x9Fw0WiT/WVnyhJhYytNncmZJYU=", base64Sha256(buf))
 						assert.False(isFileExists("app/exec.txt"))
+						list, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"exec.txt", "hello.rb", "world.rb"}, list)
 						return nil
 					},
 				),
@@ -666,12 +685,12 @@ func TestFiles_removePrefix(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create", "touch lib/exec.txt"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "-1"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "1Kjg4Dzp2HopXIgUaSQMqXoqcQ+0l01blVXUpnZ4De8="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "rFr58zR6s4VEMXZU5IQ8Ng=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "PWBibTpvogRWtqhU0R4uuFt45siIj5zKodWeqjWIclg="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "nbPS9zeb2H/xcvW+Mc75Eg=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("1Kjg4Dzp2HopXIgUaSQMqXoqcQ+0l01blVXUpnZ4De8=", base64Sha256(buf))
+						assert.Equal("PWBibTpvogRWtqhU0R4uuFt45siIj5zKodWeqjWIclg=", base64Sha256(buf))
 						assert.True(isFileExists("app/lib/exec.txt"))
 						list, err := listZip(buf)
 						require.NoError(err)
@@ -727,12 +746,12 @@ func TestContents_removePrefix(t *testing.T) {
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "contents.app/lib/const.rb", "A = 100"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "output", "my-app.zip"),
 					resource.TestCheckResourceAttr("lambdazip_file.my_app", "triggers.hello_rb", "06db2c7a260efaf6e2e3f4c635c83506f1f40f6d3898e0e6025e3e55f44ddebe"),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "gAG8+V7J4hO7MFdEE+qy4XPr4ab9yGh8iRYW31ElfZk="),
-					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "ARsi1j0/srIiuzyZo2Ywnw=="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64sha256", "mST1zkRxwhUgRuWtLhGZA2Jveofd5Z7mZ9iW6L9SIHc="),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "base64md5", "6e1QqwwRH/7Psyb+ig7cng=="),
 					func(*terraform.State) error {
 						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						assert.Equal("gAG8+V7J4hO7MFdEE+qy4XPr4ab9yGh8iRYW31ElfZk=", base64Sha256(buf))
+						assert.Equal("mST1zkRxwhUgRuWtLhGZA2Jveofd5Z7mZ9iW6L9SIHc=", base64Sha256(buf))
 						list, err := listZip(buf)
 						require.NoError(err)
 						assert.Equal([]string{"README.md", "hello.rb", "lib/const.rb", "world.rb"}, list)
@@ -744,11 +763,11 @@ func TestContents_removePrefix(t *testing.T) {
 	})
 }
 
-// TestFiles_concurrent creates two lambdazip_file resources with different
+// TestFiles_concurrent creates lambdazip_file resources with different
 // base_dir values in a single apply, which Terraform builds concurrently. It
 // guards against the cwd race where parallel builds produced zips with the
-// wrong contents. With the serialization in place each zip contains only its
-// own files.
+// wrong contents. Each before_create waits until all of the builds have
+// started, so the test also fails if the builds are serialized.
 func TestFiles_concurrent(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -757,24 +776,38 @@ func TestFiles_concurrent(t *testing.T) {
 	os.Chdir(t.TempDir())
 	defer os.Chdir(cwd)
 
-	os.Mkdir("a", 0755)
-	os.WriteFile("a/a1.txt", []byte("a1"), 0644)
-	os.WriteFile("a/a2.txt", []byte("a2"), 0644)
-	os.Mkdir("b", 0755)
-	os.WriteFile("b/b1.txt", []byte("b1"), 0644)
-	os.WriteFile("b/b2.txt", []byte("b2"), 0644)
+	dir, _ := os.Getwd()
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		os.Mkdir(name, 0755)
+		os.WriteFile(name+"/"+name+"1.txt", []byte(name+"1"), 0644)
+		os.WriteFile(name+"/"+name+"2.txt", []byte(name+"2"), 0644)
+	}
+
+	os.WriteFile("wait.sh", []byte(fmt.Sprintf(`
+		touch %[1]s/started-$1
+		for i in $(seq 100); do
+			if [ $(ls %[1]s | grep -c ^started-) -ge 4 ]; then
+				pwd > cwd.out
+				exit 0
+			fi
+			sleep 0.1
+		done
+		echo "timed out waiting for concurrent builds" >&2
+		exit 1
+	`, dir)), 0644)
 
 	resource.Test(t, resource.TestCase{
 		IsUnitTest:               true,
 		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
 		Steps: []resource.TestStep{
 			{
-				Config: `
+				Config: fmt.Sprintf(`
 					resource "lambdazip_file" "a" {
 						base_dir      = "a"
 						sources       = ["**/*.txt"]
 						output        = "a.zip"
-						before_create = "sleep 1"
+						before_create = "sh %[1]s/wait.sh a"
 						use_temp_dir  = true
 					}
 
@@ -782,23 +815,2233 @@ func TestFiles_concurrent(t *testing.T) {
 						base_dir      = "b"
 						sources       = ["**/*.txt"]
 						output        = "b.zip"
-						before_create = "sleep 1"
+						before_create = "sh %[1]s/wait.sh b"
 						use_temp_dir  = true
 					}
+
+					resource "lambdazip_file" "c" {
+						base_dir      = "c"
+						sources       = ["**/*.txt"]
+						output        = "c.zip"
+						before_create = "sh %[1]s/wait.sh c"
+					}
+
+					resource "lambdazip_file" "d" {
+						base_dir      = "d"
+						sources       = ["**/*.txt"]
+						output        = "d.zip"
+						before_create = "sh %[1]s/wait.sh d"
+					}
+				`, dir),
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						for _, name := range []string{"a", "b", "c", "d"} {
+							buf, err := os.ReadFile(name + ".zip")
+							require.NoError(err)
+							list, err := listZip(buf)
+							require.NoError(err)
+							assert.Equal([]string{name + "1.txt", name + "2.txt"}, list)
+						}
+
+						for _, name := range []string{"c", "d"} {
+							buf, err := os.ReadFile(name + "/cwd.out")
+							require.NoError(err)
+							assert.True(strings.HasSuffix(strings.TrimSpace(string(buf)), "/"+name))
+						}
+
+						assert.False(isFileExists("a/cwd.out"))
+						assert.False(isFileExists("b/cwd.out"))
+
+						wd, err := os.Getwd()
+						require.NoError(err)
+						assert.Equal(dir, wd)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_fileMode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/bootstrap", []byte("#!/bin/sh"), 0755)
+	os.WriteFile("app/README.md", []byte("# bootstrap"), 0644)
+	os.Chmod("app/bootstrap", 0755)
+	os.Chmod("app/README.md", 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_script" {
+						output            = "my-script.zip"
+						default_file_mode = "rwxr-xr-x"
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+				`,
+				ExpectError: regexp.MustCompile(`must be an octal file mode`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["bootstrap", "README.md"]
+						output   = "my-app.zip"
+
+						contents = {
+							"handler.sh" = "echo hello"
+						}
+					}
+
+					resource "lambdazip_file" "my_script" {
+						output            = "my-script.zip"
+						default_file_mode = "0755"
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
 				`,
 				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckNoResourceAttr("lambdazip_file.my_app", "default_file_mode"),
+					resource.TestCheckResourceAttr("lambdazip_file.my_script", "default_file_mode", "0755"),
 					func(*terraform.State) error {
-						bufA, err := os.ReadFile("a.zip")
+						buf, err := os.ReadFile("my-app.zip")
 						require.NoError(err)
-						listA, err := listZip(bufA)
+						modes, err := modeZip(buf)
 						require.NoError(err)
-						assert.Equal([]string{"a1.txt", "a2.txt"}, listA)
+						assert.Equal(map[string]fs.FileMode{
+							"README.md":  0644,
+							"bootstrap":  0755,
+							"handler.sh": 0644,
+						}, modes)
 
-						bufB, err := os.ReadFile("b.zip")
+						buf, err = os.ReadFile("my-script.zip")
+						require.NoError(err)
+						modes, err = modeZip(buf)
 						require.NoError(err)
-						listB, err := listZip(bufB)
+						assert.Equal(map[string]fs.FileMode{
+							"bootstrap": 0755,
+						}, modes)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_reproducible(t *testing.T) {
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("a", 0755)
+	os.WriteFile("a/bootstrap", []byte("#!/bin/sh"), 0755)
+	os.WriteFile("a/hello.rb", []byte("puts 'world'"), 0644)
+	os.Mkdir("b", 0700)
+	os.WriteFile("b/bootstrap", []byte("#!/bin/sh"), 0700)
+	os.WriteFile("b/hello.rb", []byte("puts 'world'"), 0600)
+	os.Chmod("b/bootstrap", 0700)
+	os.Chmod("b/hello.rb", 0600)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes("b/bootstrap", yesterday, yesterday)
+	os.Chtimes("b/hello.rb", yesterday, yesterday)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "a" {
+						base_dir     = "a"
+						sources      = ["**"]
+						output       = "a.zip"
+						reproducible = true
+
+						contents = {
+							"config.json" = "{}"
+						}
+					}
+
+					resource "lambdazip_file" "b" {
+						base_dir     = "b"
+						sources      = ["**"]
+						output       = "b.zip"
+						reproducible = true
+
+						contents = {
+							"config.json" = "{}"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.a", "reproducible", "true"),
+					resource.TestCheckResourceAttrPair("lambdazip_file.a", "base64sha256", "lambdazip_file.b", "base64sha256"),
+					resource.TestCheckResourceAttrPair("lambdazip_file.a", "base64md5", "lambdazip_file.b", "base64md5"),
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_sourceDateEpoch(t *testing.T) {
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+
+	config := func(epoch int) string {
+		return fmt.Sprintf(`
+			resource "lambdazip_file" "env" {
+				base_dir     = "app"
+				sources      = ["**"]
+				output       = "env.zip"
+				reproducible = true
+			}
+
+			resource "lambdazip_file" "attr" {
+				base_dir          = "app"
+				sources           = ["**"]
+				output            = "attr.zip"
+				reproducible      = true
+				source_date_epoch = %d
+			}
+		`, epoch)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: config(1700000000),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.attr", "source_date_epoch", "1700000000"),
+					resource.TestCheckResourceAttrPair("lambdazip_file.env", "base64sha256", "lambdazip_file.attr", "base64sha256"),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: config(1600000000),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.env", plancheck.ResourceActionNoop),
+						plancheck.ExpectResourceAction("lambdazip_file.attr", plancheck.ResourceActionUpdate),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(s *terraform.State) error {
+						env := s.RootModule().Resources["lambdazip_file.env"].Primary.Attributes["base64sha256"]
+						attr := s.RootModule().Resources["lambdazip_file.attr"].Primary.Attributes["base64sha256"]
+
+						if env == attr {
+							return fmt.Errorf("base64sha256 of env.zip and attr.zip are the same: %s", env)
+						}
+
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_contentSha256(t *testing.T) {
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte(strings.Repeat("puts 'world'\n", 100)), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "stored" {
+						base_dir          = "app"
+						sources           = ["**"]
+						output            = "stored.zip"
+						compression_level = 0
+
+						contents = {
+							"config.json" = "{}"
+						}
+					}
+
+					resource "lambdazip_file" "best" {
+						base_dir          = "app"
+						sources           = ["**"]
+						output            = "best.zip"
+						compression_level = 9
+
+						contents = {
+							"config.json" = "{}"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr("lambdazip_file.stored", "content_sha256", regexp.MustCompile(`^[0-9a-f]{64}$`)),
+					resource.TestCheckResourceAttrPair("lambdazip_file.stored", "content_sha256", "lambdazip_file.best", "content_sha256"),
+					func(s *terraform.State) error {
+						stored := s.RootModule().Resources["lambdazip_file.stored"].Primary.Attributes["base64sha256"]
+						best := s.RootModule().Resources["lambdazip_file.best"].Primary.Attributes["base64sha256"]
+
+						if stored == best {
+							return fmt.Errorf("base64sha256 of stored.zip and best.zip are the same: %s", stored)
+						}
+
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_contentSha256Upgrade(t *testing.T) {
+	// The previous release runs in another process, so the paths must not
+	// depend on the working directory.
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "app"), 0755)
+	os.WriteFile(filepath.Join(dir, "app", "hello.rb"), []byte("puts 'world'"), 0644)
+
+	config := fmt.Sprintf(`
+		resource "lambdazip_file" "my_app" {
+			base_dir = "%[1]s/app"
+			sources  = ["**"]
+			output   = "%[1]s/my-app.zip"
+		}
+	`, filepath.ToSlash(dir))
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest: true,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				ExternalProviders: testAccPreviousRelease,
+				Config:            config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckNoResourceAttr("lambdazip_file.my_app", "content_sha256"),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+				Config:                   config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("content_sha256")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr("lambdazip_file.my_app", "content_sha256", regexp.MustCompile(`^[0-9a-f]{64}$`)),
+				),
+			},
+			// Step 3 =====================================================
+			{
+				ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+				Config:                   config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectEmptyPlan(),
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestFiles_outputDrift(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0755)
+
+	config := `
+		resource "lambdazip_file" "my_app" {
+			base_dir = "app"
+			sources  = ["**/*.rb"]
+			output   = "my-app.zip"
+		}
+	`
+
+	var base64sha256 string
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					func(s *terraform.State) error {
+						base64sha256 = s.RootModule().Resources["lambdazip_file.my_app"].Primary.Attributes["base64sha256"]
+						return nil
+					},
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.WriteFile("my-app.zip", []byte("broken"), 0644)
+					require.NoError(err)
+				},
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			// Step 3 =====================================================
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal(base64sha256, base64Sha256(buf))
+						return nil
+					},
+				),
+			},
+			// Step 4 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.Remove("my-app.zip")
+					require.NoError(err)
+				},
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+func TestFiles_rebuildIfMissing(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0755)
+
+	config := `
+		resource "lambdazip_file" "my_app" {
+			base_dir           = "app"
+			sources            = ["**/*.rb"]
+			output             = "my-app.zip"
+			before_create      = "touch exec.txt"
+			rebuild_if_missing = true
+		}
+	`
+
+	var base64sha256 string
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "rebuild_if_missing", "true"),
+					func(s *terraform.State) error {
+						base64sha256 = s.RootModule().Resources["lambdazip_file.my_app"].Primary.Attributes["base64sha256"]
+						return nil
+					},
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.Remove("my-app.zip")
+					require.NoError(err)
+					err = os.Remove("app/exec.txt")
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectEmptyPlan(),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal(base64sha256, base64Sha256(buf))
+						assert.True(isFileExists("app/exec.txt"))
+						return nil
+					},
+				),
+			},
+			// Step 3 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.Remove("my-app.zip")
+					require.NoError(err)
+					err = os.WriteFile("app/hello.rb", []byte("print 'world'"), 0755)
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionCreate),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(s *terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.NotEqual(base64sha256, base64Sha256(buf))
+						assert.Equal(s.RootModule().Resources["lambdazip_file.my_app"].Primary.Attributes["base64sha256"], base64Sha256(buf))
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_deleteOutputOnDestroy(t *testing.T) {
+	assert := assert.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0755)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**/*.rb"]
+						output                   = "my-app.zip"
+						delete_output_on_destroy = true
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "delete_output_on_destroy", "true"),
+					func(*terraform.State) error {
+						assert.True(isFileExists("my-app.zip"))
+						return nil
+					},
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					locals {}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						assert.False(isFileExists("my-app.zip"))
+						return nil
+					},
+				),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**/*.rb"]
+						output                   = "../my-app.zip"
+						delete_output_on_destroy = true
+					}
+				`,
+			},
+			// Step 4 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**/*.rb"]
+						output                   = "../my-app.zip"
+						delete_output_on_destroy = true
+					}
+				`,
+				Destroy:     true,
+				ExpectError: regexp.MustCompile(`my-app.zip is outside the working directory and base_dir`),
+			},
+			// Step 5 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**/*.rb"]
+						output   = "../my-app.zip"
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						assert.True(isFileExists("../my-app.zip"))
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_deleteOutputOnDestroyWithCreateBeforeDestroy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0755)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**/*.rb"]
+						output                   = "my-app.zip"
+						delete_output_on_destroy = true
+
+						triggers = {
+							hello_rb = filesha256("app/hello.rb"),
+						}
+
+						lifecycle {
+							create_before_destroy = true
+						}
+					}
+				`,
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**/*.rb"]
+						output                   = "my-app.zip"
+						delete_output_on_destroy = true
+
+						triggers = {
+							hello_rb = filesha256("app/hello.rb"),
+						}
+
+						lifecycle {
+							create_before_destroy = true
+						}
+					}
+				`,
+				PreConfig: func() {
+					err := os.WriteFile("app/hello.rb", []byte("print 'world'"), 0755)
+					require.NoError(err)
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(s *terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal(s.RootModule().Resources["lambdazip_file.my_app"].Primary.Attributes["base64sha256"], base64Sha256(buf))
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_symlinks(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/node_modules/tool", 0755)
+	os.MkdirAll("app/node_modules/.bin", 0755)
+	os.WriteFile("app/node_modules/tool/cli.js", []byte("console.log('tool')"), 0755)
+	os.Symlink("../tool/cli.js", "app/node_modules/.bin/tool")
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+						symlinks = "copy"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`value must be one of`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+						symlinks = "error"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`node_modules/.bin/tool is a symbolic link`),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+						symlinks = "preserve"
+					}
+				`,
+				PreConfig: func() {
+					// Links are stored as they are, so the target need not exist
+					err := os.Symlink("../other/cli.js", "app/node_modules/.bin/other")
+					require.NoError(err)
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "symlinks", "preserve"),
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						modes, err := modeZip(buf)
+						require.NoError(err)
+						assert.Equal(map[string]fs.FileMode{
+							"node_modules/.bin/other":  fs.ModeSymlink | 0777,
+							"node_modules/.bin/tool":   fs.ModeSymlink | 0777,
+							"node_modules/tool/cli.js": 0755,
+						}, modes)
+						return nil
+					},
+				),
+			},
+			// Step 4 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+						symlinks = "preserve"
+					}
+				`,
+				PreConfig: func() {
+					err := os.Remove("app/node_modules/.bin/other")
+					require.NoError(err)
+					err = os.Symlink("../another/cli.js", "app/node_modules/.bin/other")
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestFiles_symlinksOutside(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	// A workspace package linked into node_modules of a monorepo
+	os.MkdirAll("packages/lib", 0755)
+	os.WriteFile("packages/lib/index.js", []byte("module.exports = {}"), 0644)
+	os.MkdirAll("app/node_modules/@org", 0755)
+	os.WriteFile("app/index.js", []byte("require('@org/lib')"), 0644)
+	os.Symlink("../../../packages/lib", "app/node_modules/@org/lib")
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+						symlinks = "preserve"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`node_modules/@org/lib is a symbolic link pointing outside base_dir`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						list, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"index.js", "node_modules/@org/lib/index.js"}, list)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_includeEmptyDirs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/config/env", 0755)
+	os.MkdirAll("app/tmp", 0755)
+	os.WriteFile("app/bootstrap", []byte("#!/bin/sh"), 0755)
+	os.WriteFile("app/config/env/prod.yml", []byte("env: prod"), 0644)
+	os.Chmod("app/bootstrap", 0755)
+	os.Chmod("app/tmp", 0755)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir        = "app"
+						sources         = ["bootstrap", "config", "tmp"]
+						output          = "my-app.zip"
+						allow_not_exist = true
+					}
+
+					resource "lambdazip_file" "my_app_with_dirs" {
+						base_dir           = "app"
+						sources            = ["bootstrap", "config", "tmp"]
+						output             = "my-app-with-dirs.zip"
+						include_empty_dirs = true
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app_with_dirs", "include_empty_dirs", "true"),
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						modes, err := modeZip(buf)
+						require.NoError(err)
+						assert.Equal(map[string]fs.FileMode{
+							"bootstrap":           0755,
+							"config/env/prod.yml": 0644,
+						}, modes)
+
+						buf, err = os.ReadFile("my-app-with-dirs.zip")
+						require.NoError(err)
+						modes, err = modeZip(buf)
+						require.NoError(err)
+						assert.Equal(map[string]fs.FileMode{
+							"bootstrap":           0755,
+							"config/env/prod.yml": 0644,
+							"tmp/":                fs.ModeDir | 0755,
+						}, modes)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_beforeCreateWithContents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_script" {
+						output        = "my-script.zip"
+						before_create = "FOO=bar"
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+				`,
+				ExpectError: regexp.MustCompile("`FOO=bar` will not run: no command to run"),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_script" {
+						output        = "my-script.zip"
+						before_create = "touch exec.txt"
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_script", "before_create", "touch exec.txt"),
+					func(*terraform.State) error {
+						assert.True(isFileExists("exec.txt"))
+						buf, err := os.ReadFile("my-script.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"bootstrap"}, files)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_interpreter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir    = "app"
+						sources     = ["**"]
+						output      = "my-app.zip"
+						interpreter = ["/bin/sh", "-c"]
+
+						before_create = <<-EOT
+							mkdir -p lib
+							ls *.rb | sed 's/^/# /' > lib/files.txt && echo ok
+						EOT
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "interpreter.#", "2"),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "interpreter.0", "/bin/sh"),
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("app/lib/files.txt")
+						require.NoError(err)
+						assert.Equal("# hello.rb\n", string(buf))
+						buf, err = os.ReadFile("my-app.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"hello.rb", "lib/files.txt"}, files)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_beforeCreateTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir              = "app"
+						sources               = ["**"]
+						output                = "my-app.zip"
+						before_create         = "true"
+						before_create_timeout = "0s"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`must be a positive duration`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir              = "app"
+						sources               = ["**"]
+						output                = "my-app.zip"
+						interpreter           = ["/bin/sh", "-c"]
+						before_create         = "echo building; sleep 30"
+						before_create_timeout = "1s"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`killed after 1(\.\d+)?s: context deadline exceeded\s+output: building`),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir              = "app"
+						sources               = ["**"]
+						output                = "my-app.zip"
+						before_create         = "true"
+						before_create_timeout = "1m"
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "before_create_timeout", "1m"),
+					func(*terraform.State) error {
+						assert.True(isFileExists("my-app.zip"))
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_build(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/src", 0755)
+	os.WriteFile("app/src/hello.rb", []byte("puts 'world'"), 0644)
+	t.Setenv("LAMBDAZIP_TEST", "inherited")
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+
+						build {
+							command = ["/bin/sh", "-c", "echo building; exit 1"]
+						}
+					}
+				`,
+				ExpectError: regexp.MustCompile(`(?s)Failed to run.*exit status 1\s+output: building`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+
+						build {
+							command     = ["pwd"]
+							working_dir = "src/../.."
+						}
+
+						build {
+							command     = ["pwd"]
+							working_dir = "/tmp"
+						}
+					}
+				`,
+				ExpectError: regexp.MustCompile(`(?s)working_dir value must be a relative path inside base_dir,\s+got: "src/../..".*working_dir value must be a relative path inside base_dir,\s+got: "/tmp"`),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						excludes = ["src/*.rb"]
+						output   = "my-app.zip"
+
+						build {
+							command     = ["/bin/sh", "-c", "mkdir -p ../dist && cp *.rb ../dist"]
+							working_dir = "src"
+						}
+
+						build {
+							command = ["/bin/sh", "-c", "echo \"$GREETING $${LAMBDAZIP_TEST:-none}\" > dist/env.txt"]
+							env = {
+								GREETING = "hello 'world'"
+							}
+							inherit_env = false
+							timeout     = "1m"
+						}
+
+						build {
+							command = ["sh", "-c", "echo \"$${LAMBDAZIP_TEST:-none}\" > dist/inherited.txt"]
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "build.#", "3"),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "build.1.env.GREETING", "hello 'world'"),
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("app/dist/env.txt")
+						require.NoError(err)
+						assert.Equal("hello 'world' none\n", string(buf))
+						buf, err = os.ReadFile("app/dist/inherited.txt")
+						require.NoError(err)
+						assert.Equal("inherited\n", string(buf))
+						buf, err = os.ReadFile("my-app.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"dist/env.txt", "dist/hello.rb", "dist/inherited.txt"}, files)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_buildLog(t *testing.T) {
+	assert := assert.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_script" {
+						output = "my-script.zip"
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+
+					resource "lambdazip_file" "my_app" {
+						output        = "my-app.zip"
+						before_create = "echo hello"
+
+						build {
+							command = ["/bin/sh", "-c", "echo world >&2"]
+						}
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+
+					resource "lambdazip_file" "my_verbose_app" {
+						output = "my-verbose-app.zip"
+
+						build {
+							command = ["/bin/sh", "-c", "seq 1 10000"]
+						}
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+
+					resource "lambdazip_file" "my_multibyte_app" {
+						output = "my-multibyte-app.zip"
+
+						build {
+							command = ["/bin/sh", "-c", "printf x; for i in $(seq 1 10000); do printf 'あ'; done"]
+						}
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckNoResourceAttr("lambdazip_file.my_script", "build_log"),
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "build_log", "$ echo hello\nhello\n$ /bin/sh -c echo world >&2\nworld\n"),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_verbose_app", "build_log", func(value string) error {
+						assert.True(strings.HasPrefix(value, "(truncated)\n"))
+						assert.True(strings.HasSuffix(value, "\n9999\n10000\n"))
+						assert.LessOrEqual(len(value), 16*1024+len("(truncated)\n"))
+						return nil
+					}),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_multibyte_app", "build_log", func(value string) error {
+						assert.True(utf8.ValidString(value))
+						assert.True(strings.HasSuffix(value, "ああ\n"))
+						return nil
+					}),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						output        = "my-app.zip"
+						before_create = "echo hello"
+
+						build {
+							command = ["/bin/sh", "-c", "echo world >&2"]
+						}
+
+						contents = {
+							"bootstrap" = "#!/bin/sh"
+						}
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionNoop),
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestFiles_requiredFiles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/main.go", []byte("package main"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir       = "app"
+						sources        = ["**"]
+						excludes       = ["*.go"]
+						output         = "my-app.zip"
+						before_create  = "touch bootstrap.exe"
+						required_files = ["bootstrap", "main.go"]
+					}
+				`,
+				ExpectError: regexp.MustCompile(`(?s)Required files are missing.*missing:\s+bootstrap \(does not exist\)\s+main.go \(not matched by sources\)\s+matched:\s+bootstrap.exe`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir       = "app"
+						sources        = ["**"]
+						excludes       = ["*.go"]
+						output         = "my-app.zip"
+						before_create  = "touch bootstrap"
+						required_files = ["./bootstrap"]
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "required_files.0", "./bootstrap"),
+					func(*terraform.State) error {
+						assert.True(isFileExists("my-app.zip"))
+						return nil
+					},
+				),
+			},
+			// Step 3 =====================================================
+			{
+				// Checked against the entry names, including contents
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["target/release/**"]
+						output           = "my-app.zip"
+						before_create    = "mkdir -p target/release && touch target/release/bootstrap"
+						interpreter      = ["/bin/sh", "-c"]
+						strip_components = 2
+						required_files   = ["bootstrap", "config.json"]
+
+						contents = {
+							"target/release/config.json" = "{}"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"bootstrap", "config.json"}, files)
+						return nil
+					},
+				),
+			},
+			// Step 4 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["target/release/**"]
+						output           = "my-app.zip"
+						strip_components = 2
+						required_files   = ["../bootstrap"]
+					}
+				`,
+				ExpectError: regexp.MustCompile(`required_files\[0\] value must be a relative path inside the zip\s+file`),
+			},
+			// Step 5 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["target/release/**"]
+						output           = "my-app.zip"
+						strip_components = 2
+						required_files   = ["target/release/bootstrap"]
+					}
+				`,
+				ExpectError: regexp.MustCompile(`(?s)Required files are missing.*missing:\s+target/release/bootstrap \(not matched by sources\)\s+matched:\s+bootstrap`),
+			},
+			// Step 6 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir       = "app"
+						sources        = ["**"]
+						output         = "my-app.zip"
+						required_files = ["target/release/bootstrap"]
+					}
+				`,
+			},
+		},
+	})
+}
+
+func TestFiles_allowNotExist(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["*.rb", "lib/**/*.rb"]
+						output   = "my-app.zip"
+					}
+				`,
+				ExpectError: regexp.MustCompile("`lib/\\*\\*/\\*.rb` matches no files in app"),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["*.rb"]
+						output           = "my-app.zip"
+						strip_components = 1
+					}
+				`,
+				ExpectError: regexp.MustCompile(`zip file would have no entries`),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir        = "app"
+						sources         = ["*.rb", "lib/**/*.rb"]
+						output          = "my-app.zip"
+						allow_not_exist = true
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "allow_not_exist", "true"),
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"hello.rb"}, files)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_onConflict(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/a", 0755)
+	os.MkdirAll("app/b", 0755)
+	os.WriteFile("app/a/x.py", []byte("print('a')"), 0644)
+	os.WriteFile("app/b/x.py", []byte("print('b')"), 0644)
+	os.WriteFile("app/a/config.json", []byte("{}"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["**"]
+						output           = "my-app.zip"
+						strip_components = 1
+						on_conflict      = "source_wins"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`duplicate entry x.py from a/x.py, b/x.py`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["a/**"]
+						output           = "my-app.zip"
+						strip_components = 1
+
+						contents = {
+							"src/config.json" = "{\"env\":\"prod\"}"
+						}
+					}
+				`,
+				ExpectError: regexp.MustCompile(`duplicate entry config.json from a/config.json, contents\["src/config.json"\]`),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir         = "app"
+						sources          = ["a/**"]
+						output           = "my-app.zip"
+						strip_components = 1
+						on_conflict      = "contents_wins"
+
+						contents = {
+							"src/config.json" = "{\"env\":\"prod\"}"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "on_conflict", "contents_wins"),
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"x.py", "config.json"}, files)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_unsafeNames(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.Mkdir("shared", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+	os.WriteFile("shared/util.rb", []byte("B = 200"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						output = "my-app.zip"
+
+						contents = {
+							"../bootstrap" = "#!/bin/sh"
+						}
+					}
+				`,
+				ExpectError: regexp.MustCompile(`must be a relative path inside the\s+zip file, got: unsafe entry name "../bootstrap": escapes the archive root`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["*.rb", "../shared/*.rb"]
+						output   = "my-app.zip"
+					}
+				`,
+				ExpectError: regexp.MustCompile(`unsafe entry name "../shared/util.rb": escapes the archive root`),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["*.rb"]
+						output   = "my-app.zip"
+
+						contents = {
+							"lib\\const.rb" = "A = 100"
+						}
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						files, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"hello.rb", "lib/const.rb"}, files)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_sourceHash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+	os.WriteFile("app/README.md", []byte("# hello.rb"), 0644)
+	os.WriteFile("app/Gemfile.lock", []byte("GEM"), 0644)
+
+	config := `
+		resource "lambdazip_file" "my_app" {
+			base_dir = "app"
+			sources  = ["**"]
+			excludes = ["*.md", "Gemfile.lock"]
+			output   = "my-app.zip"
+			watch    = ["Gemfile.lock"]
+		}
+	`
+
+	var sourceHash string
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "watch.0", "Gemfile.lock"),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "source_hash", func(value string) error {
+						assert.Len(value, 44)
+						sourceHash = value
+						return nil
+					}),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					// Excluded and not watched
+					err := os.WriteFile("app/README.md", []byte("# hello"), 0644)
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionNoop),
+					},
+				},
+			},
+			// Step 3 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.WriteFile("app/Gemfile.lock", []byte("GEM\n  specs:"), 0644)
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "source_hash", func(value string) error {
+						assert.NotEqual(sourceHash, value)
+						return nil
+					}),
+				),
+			},
+			// Step 4 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.WriteFile("app/hello.rb", []byte("puts 'hello'"), 0644)
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						list, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"hello.rb"}, list)
+						return nil
+					},
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						assert.Equal(base64Sha256(buf), value)
+						return nil
+					}),
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_sourceHashChangedByDependency(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("lib", 0755)
+	os.WriteFile("lib/lib.rb", []byte("puts 'world'"), 0644)
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'hello'"), 0644)
+
+	// lib writes into the base_dir of app, which is rebuilt with it.
+	config := `
+		resource "lambdazip_file" "lib" {
+			base_dir      = "lib"
+			sources       = ["**"]
+			output        = "lib.zip"
+			before_create = "cp lib.rb ../app/lib.rb"
+			interpreter   = ["/bin/sh", "-c"]
+		}
+
+		resource "lambdazip_file" "app" {
+			base_dir = "app"
+			sources  = ["**"]
+			output   = "app.zip"
+
+			triggers = {
+				lib = lambdazip_file.lib.base64sha256
+			}
+		}
+	`
+
+	var sourceHash string
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrWith("lambdazip_file.app", "source_hash", func(value string) error {
+						sourceHash = value
+						return nil
+					}),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: config,
+				PreConfig: func() {
+					err := os.WriteFile("lib/lib.rb", []byte("puts 'lib'"), 0644)
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.lib", plancheck.ResourceActionUpdate),
+						plancheck.ExpectResourceAction("lambdazip_file.app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.app", tfjsonpath.New("source_hash")),
+						plancheck.ExpectUnknownValue("lambdazip_file.app", tfjsonpath.New("base64sha256")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("app/lib.rb")
+						require.NoError(err)
+						assert.Equal("puts 'lib'", string(buf))
+						return nil
+					},
+					resource.TestCheckResourceAttrWith("lambdazip_file.app", "source_hash", func(value string) error {
+						assert.NotEqual(sourceHash, value)
+						return nil
+					}),
+				),
+			},
+			// Step 3 =====================================================
+			{
+				Config: config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.lib", plancheck.ResourceActionNoop),
+						plancheck.ExpectResourceAction("lambdazip_file.app", plancheck.ResourceActionNoop),
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestFiles_sourceHashUpgrade(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The previous release runs in another process, so the paths must not
+	// depend on the working directory.
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "app"), 0755)
+	os.WriteFile(filepath.Join(dir, "app", "hello.rb"), []byte("puts 'world'"), 0644)
+
+	config := fmt.Sprintf(`
+		resource "lambdazip_file" "my_app" {
+			base_dir = "%[1]s/app"
+			sources  = ["**"]
+			output   = "%[1]s/my-app.zip"
+		}
+	`, filepath.ToSlash(dir))
+
+	var base64sha256 string
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest: true,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				ExternalProviders: testAccPreviousRelease,
+				Config:            config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckNoResourceAttr("lambdazip_file.my_app", "source_hash"),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+						base64sha256 = value
+						return nil
+					}),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+				Config:                   config,
+				PreConfig: func() {
+					// Not detected by the previous release
+					err := os.WriteFile(filepath.Join(dir, "app", "hello.rb"), []byte("puts 'hello'"), 0644)
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("source_hash")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrSet("lambdazip_file.my_app", "source_hash"),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+						assert.NotEqual(base64sha256, value)
+						buf, err := os.ReadFile(filepath.Join(dir, "my-app.zip"))
+						require.NoError(err)
+						assert.Equal(base64Sha256(buf), value)
+						return nil
+					}),
+				),
+			},
+			// Step 3 =====================================================
+			{
+				ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+				Config:                   config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectEmptyPlan(),
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestFiles_planDigests(t *testing.T) {
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+
+	config := `
+		resource "lambdazip_file" "my_app" {
+			base_dir     = "app"
+			sources      = ["**"]
+			output       = "my-app.zip"
+			reproducible = true
+
+			contents = {
+				"config.json" = "{}"
+			}
+		}
+	`
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						planCheckFunc(func() {
+							err := os.WriteFile("app/hello.rb", []byte("puts 'hello'"), 0644)
+							require.NoError(err)
+						}),
+					},
+				},
+				// Terraform plans again before apply and rejects the new hashes
+				ExpectError: regexp.MustCompile(`invalid new value for\s+\.base64sha256`),
+			},
+			// Step 2 =====================================================
+			{
+				Config: config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectKnownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256"), knownvalue.NotNull()),
+						plancheck.ExpectKnownValue("lambdazip_file.my_app", tfjsonpath.New("base64md5"), knownvalue.NotNull()),
+						plancheck.ExpectKnownValue("lambdazip_file.my_app", tfjsonpath.New("content_sha256"), knownvalue.NotNull()),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+						buf, err := os.ReadFile("my-app.zip")
+						require.NoError(err)
+						if value != base64Sha256(buf) {
+							return fmt.Errorf("base64sha256 %s does not match my-app.zip", value)
+						}
+						return nil
+					}),
+				),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir      = "app"
+						sources       = ["**"]
+						output        = "my-app.zip"
+						reproducible  = true
+						before_create = "touch hello.rb"
+
+						contents = {
+							"config.json" = "{}"
+						}
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256")),
+					},
+				},
+			},
+			// Step 4 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir = "app"
+						sources  = ["**"]
+						output   = "my-app.zip"
+
+						contents = {
+							"config.json" = "{}"
+						}
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256")),
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestFiles_updateRebuild(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte(strings.Repeat("puts 'world'\n", 100)), 0644)
+
+	var base64sha256, contentSha256 string
+
+	checkOutput := resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+		buf, err := os.ReadFile("my-app.zip")
+		require.NoError(err)
+		assert.Equal(base64Sha256(buf), value)
+		return nil
+	})
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir      = "app"
+						sources       = ["*.rb"]
+						output        = "my-app.zip"
+						before_create = "touch exec.txt"
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					checkOutput,
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+						base64sha256 = value
+						return nil
+					}),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "content_sha256", func(value string) error {
+						contentSha256 = value
+						return nil
+					}),
+				),
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir          = "app"
+						sources           = ["*.rb"]
+						output            = "my-app.zip"
+						before_create     = "touch exec.txt"
+						compression_level = 0
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256")),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("build_log")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					checkOutput,
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "compression_level", "0"),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "base64sha256", func(value string) error {
+						assert.NotEqual(base64sha256, value)
+						return nil
+					}),
+					resource.TestCheckResourceAttrWith("lambdazip_file.my_app", "content_sha256", func(value string) error {
+						assert.Equal(contentSha256, value)
+						return nil
+					}),
+				),
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir          = "app"
+						sources           = ["*.rb"]
+						output            = "my-app.zip"
+						before_create     = "touch exec.txt"
+						compression_level = 0
+						use_temp_dir      = true
+					}
+				`,
+				PreConfig: func() {
+					err := os.Remove("app/exec.txt")
+					require.NoError(err)
+				},
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					checkOutput,
+					resource.TestCheckResourceAttr("lambdazip_file.my_app", "use_temp_dir", "true"),
+					func(*terraform.State) error {
+						// before_create ran again, in the temporary directory
+						assert.False(isFileExists("app/exec.txt"))
+						return nil
+					},
+				),
+			},
+			// Step 4 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir     = "app"
+						sources      = ["*.rb"]
+						output       = "my-app.zip"
+						reproducible = true
+					}
+				`,
+			},
+			// Step 5 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir          = "app"
+						sources           = ["*.rb"]
+						output            = "my-app.zip"
+						reproducible      = true
+						compression_level = 9
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectKnownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256"), knownvalue.NotNull()),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					checkOutput,
+				),
+			},
+		},
+	})
+}
+
+func TestFiles_inPlaceUpdate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.Mkdir("app", 0755)
+	os.WriteFile("app/hello.rb", []byte("puts 'world'"), 0644)
+	os.WriteFile("app/README.md", []byte("# hello.rb"), 0644)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Step 1 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**"]
+						output                   = "a.zip"
+						delete_output_on_destroy = true
+					}
+				`,
+			},
+			// Step 2 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**"]
+						output                   = "a.zip"
+						delete_output_on_destroy = true
+						rebuild_if_missing       = true
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectKnownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256"), knownvalue.NotNull()),
+					},
+				},
+			},
+			// Step 3 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**"]
+						excludes                 = ["*.md"]
+						output                   = "a.zip"
+						delete_output_on_destroy = true
+						rebuild_if_missing       = true
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+						plancheck.ExpectUnknownValue("lambdazip_file.my_app", tfjsonpath.New("base64sha256")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(*terraform.State) error {
+						buf, err := os.ReadFile("a.zip")
+						require.NoError(err)
+						list, err := listZip(buf)
+						require.NoError(err)
+						assert.Equal([]string{"hello.rb"}, list)
+						return nil
+					},
+				),
+			},
+			// Step 4 =====================================================
+			{
+				Config: `
+					resource "lambdazip_file" "my_app" {
+						base_dir                 = "app"
+						sources                  = ["**"]
+						excludes                 = ["*.md"]
+						output                   = "b.zip"
+						delete_output_on_destroy = true
+						rebuild_if_missing       = true
+					}
+				`,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("lambdazip_file.my_app", plancheck.ResourceActionUpdate),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					func(s *terraform.State) error {
+						assert.False(isFileExists("a.zip"))
+						buf, err := os.ReadFile("b.zip")
 						require.NoError(err)
-						assert.Equal([]string{"b1.txt", "b2.txt"}, listB)
+						assert.Equal(s.RootModule().Resources["lambdazip_file.my_app"].Primary.Attributes["base64sha256"], base64Sha256(buf))
 						return nil
 					},
 				),
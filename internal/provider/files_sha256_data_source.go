@@ -118,28 +118,17 @@ func (d *FilesSha256DataSource) Read(ctx context.Context, req datasource.ReadReq
 			globOpts = append(globOpts, doublestar.WithFailOnPatternNotExist())
 		}
 
-		// glob and hashing read cwd-relative paths, so they must not run while a
-		// resource Create has chdir'd elsewhere. Share chdirMu as a reader.
-		func() {
-			chdirMu.RLock()
-			defer chdirMu.RUnlock()
+		globbed, err := glob.Glob(".", files, excludes, globOpts...)
 
-			globbed, err := glob.Glob(files, excludes, globOpts...)
-
-			if err != nil {
-				resp.Diagnostics.AddError("Failed to glob files", err.Error())
-				return
-			}
-
-			mFiles, err = hash.Sha256Map(globbed)
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to glob files", err.Error())
+			return
+		}
 
-			if err != nil {
-				resp.Diagnostics.AddError("Failed to calculate sha256sum", err.Error())
-				return
-			}
-		}()
+		mFiles, err = hash.Sha256Map(".", globbed)
 
-		if resp.Diagnostics.HasError() {
+		if err != nil {
+			resp.Diagnostics.AddError("Failed to calculate sha256sum", err.Error())
 			return
 		}
 	}
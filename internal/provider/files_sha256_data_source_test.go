@@ -93,6 +93,19 @@ func TestFilesSha256_basic(t *testing.T) {
 					resource.TestCheckResourceAttr("data.lambdazip_files_sha256.trigger", "map.app/lib/const.rb", "62ed3c7896eb965afcfabafe23828a526fcc4fdc8c9e43ed65f3ffecf140036f"),
 				),
 			},
+			// Step 7 =====================================================
+			{
+				// Directories are not expanded
+				Config: `
+					data "lambdazip_files_sha256" "trigger" {
+						files = ["app/*", "app/lib"]
+					}
+				`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.lambdazip_files_sha256.trigger", "map.%", "3"),
+					resource.TestCheckNoResourceAttr("data.lambdazip_files_sha256.trigger", "map.app/lib/const.rb"),
+				),
+			},
 		},
 	})
 }
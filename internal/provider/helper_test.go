@@ -3,9 +3,13 @@ package provider_test
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"io/fs"
 	"os"
+
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
 )
 
 func base64Sha256(buf []byte) string {
@@ -35,3 +39,27 @@ func listZip(src []byte) ([]string, error) {
 
 	return list, nil
 }
+
+func modeZip(src []byte) (map[string]fs.FileMode, error) {
+	buf := bytes.NewReader(src)
+	r, err := zip.NewReader(buf, int64(len(src)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	modes := map[string]fs.FileMode{}
+
+	for _, file := range r.File {
+		modes[file.Name] = file.Mode()
+	}
+
+	return modes, nil
+}
+
+// planCheckFunc runs f between the plan and the apply of a step.
+type planCheckFunc func()
+
+func (f planCheckFunc) CheckPlan(context.Context, plancheck.CheckPlanRequest, *plancheck.CheckPlanResponse) {
+	f()
+}
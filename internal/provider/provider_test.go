@@ -3,9 +3,19 @@ package provider_test
 import (
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 	"github.com/winebarrel/terraform-provider-lambdazip/internal/provider"
 )
 
 var testAccProtoV6ProviderFactories = map[string]func() (tfprotov6.ProviderServer, error){
 	"lambdazip": providerserver.NewProtocol6WithError(provider.New("test")()),
 }
+
+// testAccPreviousRelease is the last release, used to create the state that
+// the provider must upgrade.
+var testAccPreviousRelease = map[string]resource.ExternalProvider{
+	"lambdazip": {
+		Source:            "winebarrel/lambdazip",
+		VersionConstraint: "0.12.0",
+	},
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+)
+
+var _ validator.String = relativeDirValidator{}
+
+// relativeDirValidator validates that a string is a path inside base_dir,
+// i.e. not absolute and not escaping it with "..".
+type relativeDirValidator struct{}
+
+func (v relativeDirValidator) Description(_ context.Context) string {
+	return "value must be a relative path inside base_dir"
+}
+
+func (v relativeDirValidator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v relativeDirValidator) ValidateString(ctx context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	if req.ConfigValue.IsNull() || req.ConfigValue.IsUnknown() {
+		return
+	}
+
+	dir := req.ConfigValue.ValueString()
+	cleaned := path.Clean(filepath.ToSlash(dir))
+
+	if filepath.IsAbs(dir) || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		resp.Diagnostics.AddAttributeError(
+			req.Path,
+			"Invalid Attribute Value",
+			fmt.Sprintf("Attribute %s %s, got: %q", req.Path, v.Description(ctx), dir),
+		)
+	}
+}
@@ -3,12 +3,158 @@ package zip
 import (
 	arzip "archive/zip"
 	"compress/flate"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/winebarrel/terraform-provider-lambdazip/internal/glob"
+	"github.com/winebarrel/terraform-provider-lambdazip/internal/hash"
 )
 
+const DefaultFileMode fs.FileMode = 0644
+
+// DefaultModTime is the modification time of the entries in a reproducible
+// archive when SOURCE_DATE_EPOCH is not set. It is the earliest time the
+// MS-DOS date format can represent.
+var DefaultModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SymlinkMode is how symbolic links in files are stored.
+type SymlinkMode string
+
+const (
+	// SymlinkFollow stores the contents of the link target.
+	SymlinkFollow SymlinkMode = "follow"
+	// SymlinkPreserve stores the link itself as a symlink entry.
+	SymlinkPreserve SymlinkMode = "preserve"
+	// SymlinkError fails if files contain a link.
+	SymlinkError SymlinkMode = "error"
+)
+
+// ConflictPolicy is how an entry from files and an entry from contents with
+// the same name are resolved.
+type ConflictPolicy string
+
+const (
+	// ConflictError fails with a DuplicateError.
+	ConflictError ConflictPolicy = "error"
+	// ConflictSourceWins keeps the entry from files.
+	ConflictSourceWins ConflictPolicy = "source_wins"
+	// ConflictContentsWins keeps the entry from contents.
+	ConflictContentsWins ConflictPolicy = "contents_wins"
+)
+
+// DuplicateError is returned by Zip when entries from different files or
+// contents have the same name, e.g. after strip.
+type DuplicateError struct {
+	Name string
+	// Paths is the original names of the entries. Names from contents are
+	// written as contents["name"].
+	Paths []string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("duplicate entry %s from %s", e.Name, strings.Join(e.Paths, ", "))
+}
+
+// ErrEmpty is returned by Zip with WithFailOnEmpty when there are no entries
+// to write.
+var ErrEmpty = errors.New("zip file would have no entries")
+
+type options struct {
+	defaultFileMode fs.FileMode
+	reproducible    bool
+	modTime         time.Time
+	symlinks        SymlinkMode
+	failOnEmpty     bool
+	onConflict      ConflictPolicy
+	content         *hash.Content
+	onOutsideLink   func(path string, target string)
+	realRoot        string
+}
+
+type Option func(*options)
+
+// WithDefaultFileMode sets the mode of the entries created from contents.
+// Entries created from files always use the mode of the source file.
+func WithDefaultFileMode(mode fs.FileMode) Option {
+	return func(o *options) {
+		o.defaultFileMode = mode
+	}
+}
+
+// WithReproducible makes the archive depend only on the entry names and data:
+// every entry gets modTime, modes are normalized to 0644 or 0755, and files
+// and contents are written together in name order.
+func WithReproducible(modTime time.Time) Option {
+	return func(o *options) {
+		o.reproducible = true
+		o.modTime = modTime
+	}
+}
+
+// WithSymlinks sets how symbolic links are stored. The default is
+// SymlinkFollow.
+func WithSymlinks(mode SymlinkMode) Option {
+	return func(o *options) {
+		o.symlinks = mode
+	}
+}
+
+// WithOnOutsideLink calls f for each file under root that SymlinkFollow reads
+// from outside root through a symbolic link, with the resolved target. Such
+// links are common (e.g. workspace packages linked into node_modules), so
+// they are reported instead of rejected.
+func WithOnOutsideLink(f func(path string, target string)) Option {
+	return func(o *options) {
+		o.onOutsideLink = f
+	}
+}
+
+// WithOnConflict sets how an entry from files and an entry from contents with
+// the same name are resolved. The default is ConflictError. Entries from
+// files, or from contents, with the same name are always an error, since
+// neither can be preferred.
+func WithOnConflict(policy ConflictPolicy) Option {
+	return func(o *options) {
+		o.onConflict = policy
+	}
+}
+
+// WithFailOnEmpty makes Zip fail with ErrEmpty instead of writing an archive
+// without entries, e.g. when all files are stripped by strip.
+func WithFailOnEmpty() Option {
+	return func(o *options) {
+		o.failOnEmpty = true
+	}
+}
+
+// SourceDateEpoch returns the time set in the SOURCE_DATE_EPOCH environment
+// variable, or DefaultModTime if it is not set.
+func SourceDateEpoch() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+
+	if epoch == "" {
+		return DefaultModTime, nil
+	}
+
+	sec, err := strconv.ParseInt(epoch, 10, 64)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %w", err)
+	}
+
+	return time.Unix(sec, 0).UTC(), nil
+}
+
 func Strip(path string, n int) string {
 	if n == 0 {
 		return path
@@ -24,89 +170,540 @@ func Strip(path string, n int) string {
 	return strings.Join(dirs[n:], "/")
 }
 
-func ZipFile(files []string, contents map[string]string, name string, level int, strip int) error {
-	f, err := os.Create(name)
+// ErrUnsafeName is returned for an entry name that would be extracted outside
+// the directory the archive is extracted to.
+var ErrUnsafeName = errors.New("unsafe entry name")
+
+var volumeRegexp = regexp.MustCompile(`^[A-Za-z]:`)
+
+// NormalizeName returns name as an entry name: backslashes are replaced with
+// slashes and the name is cleaned. An empty or absolute name, or a name that
+// escapes the root of the archive, is an ErrUnsafeName.
+func NormalizeName(name string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+
+	switch {
+	case cleaned == ".":
+		return "", fmt.Errorf("%w %q: is empty", ErrUnsafeName, name)
+	case path.IsAbs(cleaned) || volumeRegexp.MatchString(cleaned):
+		return "", fmt.Errorf("%w %q: is absolute", ErrUnsafeName, name)
+	case escapes(cleaned):
+		return "", fmt.Errorf("%w %q: escapes the archive root", ErrUnsafeName, name)
+	}
+
+	return cleaned, nil
+}
+
+// EntryName returns the entry name of a file or contents key name with n
+// leading components removed, or "" if nothing is left. The name is cleaned
+// before stripping so that e.g. "./" is not counted as a component.
+func EntryName(name string, n int) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	stripped := Strip(cleaned, n)
+
+	if stripped == "" || stripped == "." {
+		return "", nil
+	}
+
+	entryName, err := NormalizeName(stripped)
+
+	if err != nil && n > 0 {
+		err = fmt.Errorf("%w (stripped from %q)", err, name)
+	}
+
+	return entryName, err
+}
+
+// Digests are the digests of a zip file, computed while it is written.
+type Digests struct {
+	Base64Sha256 string
+	Base64Md5    string
+	// ContentSha256 is the digest of the entry names, modes and uncompressed
+	// data, which does not depend on how the entries are compressed.
+	ContentSha256 string
+}
+
+// ZipFile writes the zip file name and returns its digests. Relative files
+// are read from root.
+//
+// The archive is written to a temporary file next to name and renamed to name
+// only when it has been written successfully, so a failed build never leaves
+// a truncated zip file and the previous one is left untouched.
+func ZipFile(root string, files []string, contents map[string]string, name string, level int, strip int, opts ...Option) (*Digests, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := f.Name()
+	renamed := false
+
+	defer func() {
+		if !renamed {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	h := hash.NewWriter()
+	content := hash.NewContent()
+	err = Zip(root, files, contents, io.MultiWriter(f, h), level, strip, withContent(opts, content)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Sync()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// os.CreateTemp creates the file with 0600.
+	err = os.Chmod(tmp, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	renamed = true
+
+	digests := &Digests{
+		Base64Sha256:  h.Base64Sha256(),
+		Base64Md5:     h.Base64Md5(),
+		ContentSha256: content.Sha256(),
+	}
+
+	return digests, nil
+}
+
+// Digest returns the digests of the zip file ZipFile would write, without
+// writing it.
+func Digest(root string, files []string, contents map[string]string, level int, strip int, opts ...Option) (*Digests, error) {
+	h := hash.NewWriter()
+	content := hash.NewContent()
+	err := Zip(root, files, contents, h, level, strip, withContent(opts, content)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	digests := &Digests{
+		Base64Sha256:  h.Base64Sha256(),
+		Base64Md5:     h.Base64Md5(),
+		ContentSha256: content.Sha256(),
+	}
+
+	return digests, nil
+}
+
+// withContent returns opts with an option that hashes the entries into
+// content, without modifying the caller's slice.
+func withContent(opts []Option, content *hash.Content) []Option {
+	return append(opts[:len(opts):len(opts)], func(o *options) { o.content = content })
+}
+
+// ContentSha256 returns the ContentSha256 digest of the zip file name.
+func ContentSha256(name string) (string, error) {
+	r, err := arzip.OpenReader(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer r.Close()
+
+	content := hash.NewContent()
+
+	for _, f := range r.File {
+		err := copyEntry(content.Entry(f.Name, f.Mode()), f)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return content.Sha256(), nil
+}
+
+func copyEntry(w io.Writer, f *arzip.File) error {
+	src, err := f.Open()
 
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	defer src.Close()
 
-	return Zip(files, contents, f, level, strip)
+	_, err = io.Copy(w, src)
+
+	return err
 }
 
-func Zip(files []string, contents map[string]string, out io.Writer, level int, strip int) error {
-	w := arzip.NewWriter(out)
+type entry struct {
+	name string
+	path string
+	file string
+	data string
+}
 
-	w.RegisterCompressor(arzip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-		return flate.NewWriter(out, level)
-	})
+// Zip writes a zip archive of files and contents to out. Relative files are
+// read from root. A directory in files is written as a directory entry; the
+// files under it are not added unless they are in files too.
+func Zip(root string, files []string, contents map[string]string, out io.Writer, level int, strip int, opts ...Option) error {
+	o := &options{
+		defaultFileMode: DefaultFileMode,
+		symlinks:        SymlinkFollow,
+		onConflict:      ConflictError,
+	}
 
-	for _, name := range files {
-		stripped := Strip(name, strip)
+	for _, opt := range opts {
+		opt(o)
+	}
 
-		if stripped == "" {
-			continue
+	entries := []entry{}
+
+	if o.symlinks == SymlinkFollow && o.onOutsideLink != nil && len(files) > 0 {
+		realRoot, err := realPath(root)
+
+		if err != nil {
+			return err
 		}
 
-		f, err := w.Create(stripped)
+		o.realRoot = realRoot
+	}
+
+	for _, name := range files {
+		entryName, err := EntryName(name, strip)
 
 		if err != nil {
 			return err
+		} else if entryName == "" {
+			continue
 		}
 
-		buf, err := os.ReadFile(name)
+		entries = append(entries, entry{name: entryName, path: name, file: glob.Path(root, name)})
+	}
+
+	contentsList := []entry{}
+
+	for name, data := range contents {
+		entryName, err := EntryName(name, strip)
 
 		if err != nil {
 			return err
+		} else if entryName == "" {
+			continue
 		}
 
-		_, err = f.Write(buf)
+		contentsList = append(contentsList, entry{name: entryName, path: name, data: data})
+	}
+
+	// Keys that are the same after strip are ordered by the original key, so
+	// that the archive and errors do not depend on the map order.
+	sort.Slice(contentsList, func(i, j int) bool {
+		if contentsList[i].name != contentsList[j].name {
+			return contentsList[i].name < contentsList[j].name
+		}
+
+		return contentsList[i].path < contentsList[j].path
+	})
+	entries = append(entries, contentsList...)
+	entries, err := resolveConflicts(entries, o.onConflict)
+
+	if err != nil {
+		return err
+	}
+
+	if o.failOnEmpty && len(entries) == 0 {
+		return ErrEmpty
+	}
+
+	if o.reproducible {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	}
+
+	w := arzip.NewWriter(out)
+
+	w.RegisterCompressor(arzip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+
+	for _, e := range entries {
+		err := write(w, e, o)
 
 		if err != nil {
 			return err
 		}
 	}
 
-	type content struct {
-		name string
-		data string
+	err = w.Close()
+
+	if err != nil {
+		return err
 	}
 
-	contentsList := []content{}
+	return nil
+}
 
-	for name, data := range contents {
-		contentsList = append(contentsList, content{name: name, data: data})
+// resolveConflicts returns entries without the ones that lose to an entry
+// with the same name under policy, or an error for the names that cannot be
+// resolved.
+func resolveConflicts(entries []entry, policy ConflictPolicy) ([]entry, error) {
+	byName := map[string][]entry{}
+
+	for _, e := range entries {
+		byName[e.name] = append(byName[e.name], e)
 	}
 
-	sort.Slice(contentsList, func(i, j int) bool { return contentsList[i].name < contentsList[j].name })
+	errs := []error{}
+	conflicts := map[string]bool{}
 
-	for _, c := range contentsList {
-		stripped := Strip(c.name, strip)
+	for _, e := range entries {
+		dups := byName[e.name]
 
-		if stripped == "" {
+		if len(dups) < 2 || conflicts[e.name] {
 			continue
 		}
 
-		f, err := w.Create(stripped)
+		conflicts[e.name] = true
+		paths := []string{}
+		nfiles := 0
+
+		for _, d := range dups {
+			if d.file != "" {
+				nfiles++
+				paths = append(paths, d.path)
+			} else {
+				paths = append(paths, fmt.Sprintf("contents[%q]", d.path))
+			}
+		}
+
+		if len(dups) > 2 || nfiles != 1 || policy == ConflictError {
+			errs = append(errs, &DuplicateError{Name: e.name, Paths: paths})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	resolved := []entry{}
+
+	for _, e := range entries {
+		if conflicts[e.name] {
+			fromFile := e.file != ""
+
+			if fromFile != (policy == ConflictSourceWins) {
+				continue
+			}
+		}
+
+		resolved = append(resolved, e)
+	}
+
+	return resolved, nil
+}
+
+func write(w *arzip.Writer, e entry, o *options) error {
+	if e.file == "" {
+		f, err := create(w, e.name, o.defaultFileMode, o)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(f, e.data)
+
+		return err
+	}
+
+	if o.symlinks != SymlinkFollow {
+		fi, err := os.Lstat(e.file)
 
 		if err != nil {
 			return err
 		}
 
-		_, err = f.Write([]byte(c.data))
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return writeSymlink(w, e, o)
+		}
+	} else if o.onOutsideLink != nil {
+		target, err := outsideTarget(e, o.realRoot)
 
 		if err != nil {
 			return err
+		} else if target != "" {
+			o.onOutsideLink(e.path, target)
 		}
 	}
 
-	err := w.Close()
+	src, err := os.Open(e.file)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	defer src.Close()
+
+	fi, err := src.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		_, err = create(w, strings.TrimSuffix(e.name, "/")+"/", fi.Mode(), o)
+		return err
+	}
+
+	f, err := create(w, e.name, fi.Mode(), o)
+
+	if err != nil {
+		return err
+	}
+
+	// Stream the file into the archive so that large files are not loaded
+	// into memory at once.
+	_, err = io.Copy(f, src)
+
+	return err
+}
+
+// outsideTarget returns the resolved path of a file under base_dir that
+// resolves to a file outside it through a symbolic link, either the file
+// itself or one of its parent directories, or "" if it stays inside. realRoot
+// is base_dir with its links resolved. Files outside base_dir, e.g.
+// "../shared/x.py", are added on purpose and are not checked.
+func outsideTarget(e entry, realRoot string) (string, error) {
+	if filepath.IsAbs(e.path) || escapes(path.Clean(filepath.ToSlash(e.path))) {
+		return "", nil
+	}
+
+	realFile, err := realPath(e.file)
+
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(realRoot, realFile)
+
+	if err != nil || escapes(filepath.ToSlash(rel)) {
+		return realFile, nil
+	}
+
+	return "", nil
+}
+
+// realPath returns the absolute path of name with its symbolic links
+// resolved.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+func writeSymlink(w *arzip.Writer, e entry, o *options) error {
+	if o.symlinks == SymlinkError {
+		return fmt.Errorf("%s is a symbolic link", e.path)
+	}
+
+	target, err := os.Readlink(e.file)
+
+	if err != nil {
+		return err
+	}
+
+	target = filepath.ToSlash(target)
+
+	// A preserved link must resolve inside both base_dir and the archive, or
+	// it would point at a file that is not in the archive once extracted.
+	if path.IsAbs(target) ||
+		(!filepath.IsAbs(e.path) && escapes(path.Join(path.Dir(filepath.ToSlash(e.path)), target))) ||
+		escapes(path.Join(path.Dir(e.name), target)) {
+		return fmt.Errorf("%s is a symbolic link pointing outside base_dir: %s", e.path, target)
+	}
+
+	f, err := create(w, e.name, fs.ModeSymlink|0777, o)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(f, target)
+
+	return err
+}
+
+func escapes(name string) bool {
+	return name == ".." || strings.HasPrefix(name, "../")
+}
+
+// create adds an entry that records mode in its external attributes, so
+// that permissions such as the executable bit of a bootstrap survive
+// extraction. A directory entry is stored without compression. Unless the
+// archive is reproducible, the modification time is left unset to keep the
+// archive independent of when the files were written.
+func create(w *arzip.Writer, name string, mode fs.FileMode, o *options) (io.Writer, error) {
+	fh := &arzip.FileHeader{
+		Name:   name,
+		Method: arzip.Deflate,
+	}
+
+	if mode.IsDir() {
+		fh.Method = arzip.Store
+	}
+
+	if o.reproducible {
+		mode = normalizeMode(mode)
+		modTime := o.modTime.UTC()
+
+		if modTime.Before(DefaultModTime) {
+			modTime = DefaultModTime
+		}
+
+		fh.Modified = modTime
+	}
+
+	fh.SetMode(mode)
+	f, err := w.CreateHeader(fh)
+
+	if err != nil || o.content == nil {
+		return f, err
+	}
+
+	return io.MultiWriter(f, o.content.Entry(name, fh.Mode())), nil
+}
+
+func normalizeMode(mode fs.FileMode) fs.FileMode {
+	if mode&fs.ModeSymlink != 0 {
+		return fs.ModeSymlink | 0777
+	}
+
+	if mode.IsDir() {
+		return fs.ModeDir | 0755
+	}
+
+	if mode.Perm()&0111 != 0 {
+		return 0755
+	}
+
+	return 0644
 }
@@ -3,9 +3,18 @@ package zip_test
 import (
 	"bytes"
 	"compress/flate"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
 	"slices"
 	"testing"
+	"time"
 
 	arzip "archive/zip"
 
@@ -32,6 +41,22 @@ func listZip(t *testing.T, src []byte) []string {
 	return list
 }
 
+func modeZip(t *testing.T, src []byte) map[string]fs.FileMode {
+	t.Helper()
+	require := require.New(t)
+
+	buf := bytes.NewReader(src)
+	r, err := arzip.NewReader(buf, int64(len(src)))
+	require.NoError(err)
+	modes := map[string]fs.FileMode{}
+
+	for _, file := range r.File {
+		modes[file.Name] = file.Mode()
+	}
+
+	return modes
+}
+
 func TestZip(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -44,7 +69,7 @@ func TestZip(t *testing.T) {
 	os.WriteFile("world.rb", []byte("puts 'hello'"), 0755)
 
 	var out bytes.Buffer
-	err := zip.Zip([]string{"hello.rb", "world.rb"}, nil, &out, -1, 0)
+	err := zip.Zip(".", []string{"hello.rb", "world.rb"}, nil, &out, -1, 0)
 	require.NoError(err)
 
 	list := listZip(t, out.Bytes())
@@ -63,7 +88,7 @@ func TestZipWithBestCompression(t *testing.T) {
 	os.WriteFile("world.rb", []byte("puts 'hello'"), 0755)
 
 	var out bytes.Buffer
-	err := zip.Zip([]string{"hello.rb", "world.rb"}, nil, &out, flate.BestCompression, 0)
+	err := zip.Zip(".", []string{"hello.rb", "world.rb"}, nil, &out, flate.BestCompression, 0)
 	require.NoError(err)
 
 	list := listZip(t, out.Bytes())
@@ -87,7 +112,7 @@ func TestZipContents(t *testing.T) {
 	}
 
 	var out bytes.Buffer
-	err := zip.Zip([]string{"hello.rb", "world.rb"}, contents, &out, -1, 0)
+	err := zip.Zip(".", []string{"hello.rb", "world.rb"}, contents, &out, -1, 0)
 	require.NoError(err)
 
 	list := listZip(t, out.Bytes())
@@ -105,15 +130,117 @@ func TestZipFile(t *testing.T) {
 	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
 	os.WriteFile("world.rb", []byte("puts 'hello'"), 0755)
 
-	err := zip.ZipFile([]string{"hello.rb", "world.rb"}, nil, "app.zip", -1, 0)
+	digests, err := zip.ZipFile(".", []string{"hello.rb", "world.rb"}, nil, "app.zip", -1, 0)
 	require.NoError(err)
 	buf, err := os.ReadFile("app.zip")
 	require.NoError(err)
 
+	fi, err := os.Stat("app.zip")
+	require.NoError(err)
+	assert.Equal(fs.FileMode(0644), fi.Mode())
+
+	sha256Sum := sha256.Sum256(buf)
+	md5Sum := md5.Sum(buf)
+	assert.Equal(base64.StdEncoding.EncodeToString(sha256Sum[:]), digests.Base64Sha256)
+	assert.Equal(base64.StdEncoding.EncodeToString(md5Sum[:]), digests.Base64Md5)
+
 	list := listZip(t, buf)
 	assert.Equal([]string{"hello.rb", "world.rb"}, list)
 }
 
+func TestZipFileContentSha256(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
+	os.WriteFile("world.rb", []byte("puts 'hello'"), 0644)
+	contents := map[string]string{"config.json": "{}"}
+
+	digests, err := zip.ZipFile(".", []string{"hello.rb", "world.rb"}, contents, "app.zip", flate.NoCompression, 0)
+	require.NoError(err)
+	assert.Len(digests.ContentSha256, 64)
+
+	contentSha256, err := zip.ContentSha256("app.zip")
+	require.NoError(err)
+	assert.Equal(digests.ContentSha256, contentSha256)
+
+	// The compression level changes the zip file but not its content
+	best, err := zip.ZipFile(".", []string{"hello.rb", "world.rb"}, contents, "app.zip", flate.BestCompression, 0)
+	require.NoError(err)
+	assert.NotEqual(digests.Base64Sha256, best.Base64Sha256)
+	assert.Equal(digests.ContentSha256, best.ContentSha256)
+
+	// Neither does the order of the entries
+	reversed, err := zip.ZipFile(".", []string{"world.rb", "hello.rb"}, contents, "app.zip", flate.NoCompression, 0)
+	require.NoError(err)
+	assert.Equal(digests.ContentSha256, reversed.ContentSha256)
+
+	os.Chmod("world.rb", 0755)
+	chmoded, err := zip.ZipFile(".", []string{"hello.rb", "world.rb"}, contents, "app.zip", flate.NoCompression, 0)
+	require.NoError(err)
+	assert.NotEqual(digests.ContentSha256, chmoded.ContentSha256)
+
+	os.WriteFile("world.rb", []byte("puts 'world'"), 0644)
+	changed, err := zip.ZipFile(".", []string{"hello.rb", "world.rb"}, contents, "app.zip", flate.NoCompression, 0)
+	require.NoError(err)
+	assert.NotEqual(digests.ContentSha256, changed.ContentSha256)
+}
+
+func TestDigest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
+	contents := map[string]string{"config.json": "{}"}
+	opts := []zip.Option{zip.WithReproducible(zip.DefaultModTime)}
+
+	digests, err := zip.Digest(".", []string{"hello.rb"}, contents, -1, 0, opts...)
+	require.NoError(err)
+	_, err = os.Stat("app.zip")
+	assert.ErrorIs(err, fs.ErrNotExist)
+
+	written, err := zip.ZipFile(".", []string{"hello.rb"}, contents, "app.zip", -1, 0, opts...)
+	require.NoError(err)
+	assert.Equal(written, digests)
+}
+
+func TestZipFileKeepPreviousOnError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.WriteFile("hello.rb", []byte("puts 'world'"), 0755)
+	os.WriteFile("app.zip", []byte("previous"), 0644)
+
+	_, err := zip.ZipFile(".", []string{"hello.rb", "not_exist.rb"}, nil, "app.zip", -1, 0)
+	require.ErrorIs(err, fs.ErrNotExist)
+
+	buf, err := os.ReadFile("app.zip")
+	require.NoError(err)
+	assert.Equal("previous", string(buf))
+
+	entries, err := os.ReadDir(".")
+	require.NoError(err)
+	names := []string{}
+
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	assert.Equal([]string{"app.zip", "hello.rb"}, names)
+}
+
 func TestZipFileWithContents(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -130,7 +257,7 @@ func TestZipFileWithContents(t *testing.T) {
 		"world2.rb": "puts 'hello2'",
 	}
 
-	err := zip.ZipFile([]string{"hello.rb", "world.rb"}, contents, "app.zip", -1, 0)
+	_, err := zip.ZipFile(".", []string{"hello.rb", "world.rb"}, contents, "app.zip", -1, 0)
 	require.NoError(err)
 	buf, err := os.ReadFile("app.zip")
 	require.NoError(err)
@@ -152,7 +279,7 @@ func TestZipWithRemovePrefix(t *testing.T) {
 	os.WriteFile("app/world.rb", []byte("puts 'hello'"), 0755)
 
 	var out bytes.Buffer
-	err := zip.Zip([]string{"app/hello.rb", "app/world.rb"}, nil, &out, -1, 1)
+	err := zip.Zip(".", []string{"app/hello.rb", "app/world.rb"}, nil, &out, -1, 1)
 	require.NoError(err)
 
 	list := listZip(t, out.Bytes())
@@ -177,13 +304,408 @@ func TestZipContentsWithRemovePrefix(t *testing.T) {
 	}
 
 	var out bytes.Buffer
-	err := zip.Zip([]string{"app/hello.rb", "app/world.rb"}, contents, &out, -1, 1)
+	err := zip.Zip(".", []string{"app/hello.rb", "app/world.rb"}, contents, &out, -1, 1)
 	require.NoError(err)
 
 	list := listZip(t, out.Bytes())
 	assert.Equal([]string{"hello.rb", "hello2.rb", "world.rb", "world2.rb"}, list)
 }
 
+func TestZipFileMode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.WriteFile("bootstrap", []byte("#!/bin/sh"), 0755)
+	os.WriteFile("hello.rb", []byte("puts 'world'"), 0644)
+	os.Chmod("bootstrap", 0755)
+	os.Chmod("hello.rb", 0644)
+
+	contents := map[string]string{
+		"world.rb": "puts 'hello'",
+	}
+
+	var out bytes.Buffer
+	err := zip.Zip(".", []string{"bootstrap", "hello.rb"}, contents, &out, -1, 0)
+	require.NoError(err)
+
+	modes := modeZip(t, out.Bytes())
+	assert.Equal(map[string]fs.FileMode{
+		"bootstrap": 0755,
+		"hello.rb":  0644,
+		"world.rb":  0644,
+	}, modes)
+}
+
+func TestZipWithDefaultFileMode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	contents := map[string]string{
+		"bootstrap": "#!/bin/sh",
+	}
+
+	var out bytes.Buffer
+	err := zip.Zip(".", nil, contents, &out, -1, 0, zip.WithDefaultFileMode(0755))
+	require.NoError(err)
+
+	modes := modeZip(t, out.Bytes())
+	assert.Equal(map[string]fs.FileMode{
+		"bootstrap": 0755,
+	}, modes)
+}
+
+func TestZipReproducible(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+
+	contents := map[string]string{
+		"app/config.json": "{}",
+	}
+
+	build := func(fileMode, execMode fs.FileMode, modTime time.Time) []byte {
+		os.Chdir(t.TempDir())
+		os.Mkdir("app", 0755)
+		os.WriteFile("app/bootstrap", []byte("#!/bin/sh"), execMode)
+		os.WriteFile("app/hello.rb", []byte("puts 'world'"), fileMode)
+		os.Chmod("app/bootstrap", execMode)
+		os.Chmod("app/hello.rb", fileMode)
+		os.Chtimes("app/bootstrap", modTime, modTime)
+		os.Chtimes("app/hello.rb", modTime, modTime)
+
+		var out bytes.Buffer
+		err := zip.Zip(".", []string{"app/bootstrap", "app/hello.rb"}, contents, &out, -1, 0, zip.WithReproducible(zip.DefaultModTime))
+		require.NoError(err)
+
+		return out.Bytes()
+	}
+
+	zip1 := build(0644, 0755, time.Now())
+	zip2 := build(0600, 0700, time.Now().Add(-24*time.Hour))
+	assert.Equal(sha256.Sum256(zip1), sha256.Sum256(zip2))
+
+	r, err := arzip.NewReader(bytes.NewReader(zip1), int64(len(zip1)))
+	require.NoError(err)
+	names := []string{}
+
+	for _, file := range r.File {
+		names = append(names, file.Name)
+		assert.True(zip.DefaultModTime.Equal(file.Modified))
+	}
+
+	assert.Equal([]string{"app/bootstrap", "app/config.json", "app/hello.rb"}, names)
+	assert.Equal(map[string]fs.FileMode{
+		"app/bootstrap":   0755,
+		"app/config.json": 0644,
+		"app/hello.rb":    0644,
+	}, modeZip(t, zip1))
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	epoch, err := zip.SourceDateEpoch()
+	require.NoError(err)
+	assert.Equal(zip.DefaultModTime, epoch)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	epoch, err = zip.SourceDateEpoch()
+	require.NoError(err)
+	assert.Equal(time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), epoch)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	_, err = zip.SourceDateEpoch()
+	assert.ErrorContains(err, "invalid SOURCE_DATE_EPOCH")
+}
+
+func TestZipWithRoot(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "app"), 0755)
+	os.WriteFile(filepath.Join(root, "app", "hello.rb"), []byte("puts 'world'"), 0755)
+	os.WriteFile(filepath.Join(root, "world.rb"), []byte("puts 'hello'"), 0755)
+
+	var out bytes.Buffer
+	err := zip.Zip(root, []string{"app/hello.rb", "world.rb"}, nil, &out, -1, 0)
+	require.NoError(err)
+
+	list := listZip(t, out.Bytes())
+	assert.Equal([]string{"app/hello.rb", "world.rb"}, list)
+}
+
+func TestZipSymlinks(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "app", "lib"), 0755)
+	os.MkdirAll(filepath.Join(root, "app", "bin"), 0755)
+	os.WriteFile(filepath.Join(root, "app", "lib", "real.rb"), []byte("puts 'world'"), 0644)
+	os.Symlink("../lib/real.rb", filepath.Join(root, "app", "bin", "link"))
+	os.Symlink("../../../outside", filepath.Join(root, "app", "bin", "outside"))
+	os.Symlink("/etc/hosts", filepath.Join(root, "app", "bin", "abs"))
+
+	readZip := func(src []byte) map[string]string {
+		r, err := arzip.NewReader(bytes.NewReader(src), int64(len(src)))
+		require.NoError(err)
+		m := map[string]string{}
+
+		for _, file := range r.File {
+			f, err := file.Open()
+			require.NoError(err)
+			buf, err := io.ReadAll(f)
+			require.NoError(err)
+			f.Close()
+			m[file.Name] = string(buf)
+		}
+
+		return m
+	}
+
+	// follow
+	var out bytes.Buffer
+	err := zip.Zip(root, []string{"app/bin/link"}, nil, &out, -1, 0)
+	require.NoError(err)
+	assert.Equal(map[string]string{"app/bin/link": "puts 'world'"}, readZip(out.Bytes()))
+	assert.Equal(map[string]fs.FileMode{"app/bin/link": 0644}, modeZip(t, out.Bytes()))
+
+	// follow links pointing outside
+	os.WriteFile(filepath.Join(root, "..", "outside"), []byte("secret"), 0644)
+	defer os.Remove(filepath.Join(root, "..", "outside"))
+
+	os.Symlink("/etc", filepath.Join(root, "app", "etc"))
+	outsideLinks := map[string]string{}
+
+	onOutsideLink := zip.WithOnOutsideLink(func(path string, target string) {
+		outsideLinks[path] = target
+	})
+
+	out.Reset()
+	err = zip.Zip(root, []string{"app/bin/abs", "app/bin/link", "app/bin/outside", "app/etc/hosts"}, nil, &out, -1, 0, onOutsideLink)
+	require.NoError(err)
+	assert.Equal([]string{"app/bin/abs", "app/bin/link", "app/bin/outside", "app/etc/hosts"}, listZip(t, out.Bytes()))
+	realOutside, _ := filepath.EvalSymlinks(filepath.Join(root, "..", "outside"))
+	realHosts, _ := filepath.EvalSymlinks("/etc/hosts")
+	assert.Equal(map[string]string{"app/bin/abs": realHosts, "app/bin/outside": realOutside, "app/etc/hosts": realHosts}, outsideLinks)
+
+	// files outside base_dir are added on purpose
+	clear(outsideLinks)
+	out.Reset()
+	err = zip.Zip(filepath.Join(root, "app", "bin"), []string{"../lib/real.rb"}, nil, &out, -1, 1, onOutsideLink)
+	require.NoError(err)
+	assert.Equal(map[string]string{"lib/real.rb": "puts 'world'"}, readZip(out.Bytes()))
+	assert.Empty(outsideLinks)
+
+	// preserve
+	out.Reset()
+	err = zip.Zip(root, []string{"app/bin/link", "app/lib/real.rb"}, nil, &out, -1, 0, zip.WithSymlinks(zip.SymlinkPreserve))
+	require.NoError(err)
+	assert.Equal(map[string]string{"app/bin/link": "../lib/real.rb", "app/lib/real.rb": "puts 'world'"}, readZip(out.Bytes()))
+	assert.Equal(map[string]fs.FileMode{"app/bin/link": fs.ModeSymlink | 0777, "app/lib/real.rb": 0644}, modeZip(t, out.Bytes()))
+
+	// preserve links pointing outside
+	for _, name := range []string{"app/bin/outside", "app/bin/abs"} {
+		err = zip.Zip(root, []string{name}, nil, io.Discard, -1, 0, zip.WithSymlinks(zip.SymlinkPreserve))
+		assert.ErrorContains(err, name+" is a symbolic link pointing outside base_dir")
+	}
+
+	err = zip.Zip(filepath.Join(root, "app", "bin"), []string{"outside"}, nil, io.Discard, -1, 0, zip.WithSymlinks(zip.SymlinkPreserve))
+	assert.ErrorContains(err, "outside is a symbolic link pointing outside base_dir")
+
+	err = zip.Zip(root, []string{"app/bin/link"}, nil, io.Discard, -1, 2, zip.WithSymlinks(zip.SymlinkPreserve))
+	assert.ErrorContains(err, "app/bin/link is a symbolic link pointing outside base_dir")
+
+	// error
+	err = zip.Zip(root, []string{"app/bin/link"}, nil, io.Discard, -1, 0, zip.WithSymlinks(zip.SymlinkError))
+	assert.ErrorContains(err, "app/bin/link is a symbolic link")
+
+	err = zip.Zip(root, []string{"app/lib/real.rb"}, nil, io.Discard, -1, 0, zip.WithSymlinks(zip.SymlinkError))
+	assert.NoError(err)
+}
+
+func TestZipDirs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("app/bin", 0755)
+	os.MkdirAll("app/tmp", 0700)
+	os.Chmod("app/tmp", 0700)
+	os.WriteFile("app/bin/hello.rb", []byte("puts 'world'"), 0755)
+	os.Chmod("app/bin/hello.rb", 0755)
+
+	var out bytes.Buffer
+	err := zip.Zip(".", []string{"app/bin/hello.rb", "app/tmp"}, nil, &out, -1, 1)
+	require.NoError(err)
+
+	modes := modeZip(t, out.Bytes())
+	assert.Equal(map[string]fs.FileMode{
+		"bin/hello.rb": 0755,
+		"tmp/":         fs.ModeDir | 0700,
+	}, modes)
+
+	out.Reset()
+	err = zip.Zip(".", []string{"app/bin/hello.rb", "app/tmp"}, nil, &out, -1, 1, zip.WithReproducible(zip.DefaultModTime))
+	require.NoError(err)
+
+	modes = modeZip(t, out.Bytes())
+	assert.Equal(map[string]fs.FileMode{
+		"bin/hello.rb": 0755,
+		"tmp/":         fs.ModeDir | 0755,
+	}, modes)
+}
+
+func TestZipFailOnEmpty(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var out bytes.Buffer
+	err := zip.Zip(".", nil, nil, &out, -1, 0)
+	require.NoError(err)
+
+	out.Reset()
+	err = zip.Zip(".", nil, map[string]string{"bootstrap": "#!/bin/sh"}, &out, -1, 1, zip.WithFailOnEmpty())
+	assert.ErrorIs(err, zip.ErrEmpty)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	_, err = zip.ZipFile(".", nil, nil, "empty.zip", -1, 0, zip.WithFailOnEmpty())
+	assert.ErrorIs(err, zip.ErrEmpty)
+	_, err = os.Stat("empty.zip")
+	assert.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestZipConflicts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(cwd)
+
+	os.MkdirAll("a", 0755)
+	os.MkdirAll("b", 0755)
+	os.WriteFile("a/x.py", []byte("print('a')"), 0644)
+	os.WriteFile("b/x.py", []byte("print('b')"), 0644)
+	os.WriteFile("a/y.py", []byte("print('y')"), 0644)
+
+	var out bytes.Buffer
+	err := zip.Zip(".", []string{"a/x.py", "b/x.py"}, nil, &out, -1, 1, zip.WithOnConflict(zip.ConflictSourceWins))
+	var dupErr *zip.DuplicateError
+	require.ErrorAs(err, &dupErr)
+	assert.Equal("x.py", dupErr.Name)
+	assert.Equal([]string{"a/x.py", "b/x.py"}, dupErr.Paths)
+	assert.EqualError(err, "duplicate entry x.py from a/x.py, b/x.py")
+
+	contents := map[string]string{
+		"c/y.py": "print('contents')",
+		"d/z.py": "print('z')",
+		"e/z.py": "print('z')",
+	}
+
+	err = zip.Zip(".", []string{"a/y.py"}, contents, &out, -1, 1)
+	assert.EqualError(err, `duplicate entry y.py from a/y.py, contents["c/y.py"]`+"\n"+
+		`duplicate entry z.py from contents["d/z.py"], contents["e/z.py"]`)
+
+	delete(contents, "e/z.py")
+
+	err = zip.Zip(".", []string{"a/y.py"}, contents, &out, -1, 1)
+	assert.EqualError(err, `duplicate entry y.py from a/y.py, contents["c/y.py"]`)
+
+	for policy, expected := range map[zip.ConflictPolicy]string{
+		zip.ConflictSourceWins:   "print('y')",
+		zip.ConflictContentsWins: "print('contents')",
+	} {
+		out.Reset()
+		err = zip.Zip(".", []string{"a/y.py"}, contents, &out, -1, 1, zip.WithOnConflict(policy))
+		require.NoError(err)
+
+		r, err := arzip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		require.NoError(err)
+		require.Len(r.File, 2)
+		assert.Equal("y.py", r.File[0].Name)
+		assert.Equal("z.py", r.File[1].Name)
+		f, err := r.File[0].Open()
+		require.NoError(err)
+		buf, _ := io.ReadAll(f)
+		assert.Equal(expected, string(buf))
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		name     string
+		expected string
+		err      string
+	}{
+		{name: "hello.rb", expected: "hello.rb"},
+		{name: `lib\const.rb`, expected: "lib/const.rb"},
+		{name: "./lib//const.rb", expected: "lib/const.rb"},
+		{name: "lib/../hello.rb", expected: "hello.rb"},
+		{name: "../hello.rb", err: `unsafe entry name "../hello.rb": escapes the archive root`},
+		{name: `lib\..\..\hello.rb`, err: `unsafe entry name "lib\\..\\..\\hello.rb": escapes the archive root`},
+		{name: "/etc/passwd", err: `unsafe entry name "/etc/passwd": is absolute`},
+		{name: `C:\hello.rb`, err: `unsafe entry name "C:\\hello.rb": is absolute`},
+		{name: "", err: `unsafe entry name "": is empty`},
+	}
+
+	for _, t := range tt {
+		name, err := zip.NormalizeName(t.name)
+
+		if t.err != "" {
+			assert.ErrorIs(err, zip.ErrUnsafeName)
+			assert.EqualError(err, t.err)
+		} else {
+			assert.NoError(err)
+			assert.Equal(t.expected, name)
+		}
+	}
+}
+
+func TestZipUnsafeNames(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "app")
+	os.MkdirAll(root, 0755)
+	os.MkdirAll(filepath.Join(dir, "shared"), 0755)
+	os.WriteFile(filepath.Join(dir, "shared", "util.rb"), []byte("B = 200"), 0644)
+
+	var out bytes.Buffer
+	err := zip.Zip(root, []string{"../shared/util.rb"}, nil, &out, -1, 0)
+	assert.EqualError(err, `unsafe entry name "../shared/util.rb": escapes the archive root`)
+
+	err = zip.Zip(root, []string{filepath.Join(dir, "shared", "util.rb")}, nil, &out, -1, 0)
+	assert.ErrorIs(err, zip.ErrUnsafeName)
+
+	err = zip.Zip(root, nil, map[string]string{"../../x.rb": "X = 1"}, &out, -1, 1)
+	assert.EqualError(err, `unsafe entry name "../x.rb": escapes the archive root (stripped from "../../x.rb")`)
+
+	out.Reset()
+	contents := map[string]string{`lib\const.rb`: "A = 100"}
+	err = zip.Zip(root, []string{"../shared/util.rb"}, contents, &out, -1, 1)
+	require.NoError(err)
+	assert.Equal([]string{"const.rb", "shared/util.rb"}, listZip(t, out.Bytes()))
+}
+
 func TestStrip(t *testing.T) {
 	assert := assert.New(t)
 
@@ -209,3 +731,26 @@ func TestStrip(t *testing.T) {
 		assert.Equal(t.expected, actual)
 	}
 }
+
+// BenchmarkZip shows that memory use does not grow with the file size
+// (compare B/op between the sub-benchmarks).
+func BenchmarkZip(b *testing.B) {
+	for _, size := range []int64{1 << 20, 16 << 20, 64 << 20} {
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			root := b.TempDir()
+			f, err := os.Create(filepath.Join(root, "large.bin"))
+			require.NoError(b, err)
+			_, err = io.CopyN(f, rand.NewChaCha8([32]byte{}), size)
+			require.NoError(b, err)
+			require.NoError(b, f.Close())
+
+			b.SetBytes(size)
+			b.ReportAllocs()
+
+			for b.Loop() {
+				err := zip.Zip(root, []string{"large.bin"}, nil, io.Discard, flate.BestSpeed, 0)
+				require.NoError(b, err)
+			}
+		})
+	}
+}